@@ -8,10 +8,13 @@ import (
 	"github.com/Meduza3/imp/token"
 )
 
-// Node represents an AST node.
+// Node represents an AST node. Pos and End delimit the source span the
+// node was parsed from; End points just past its last character.
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
+	End() token.Position
 }
 
 // Command represents a statement in the language.
@@ -26,35 +29,103 @@ type Expression interface {
 	expressionNode()
 }
 
+// Program is a parsed compilation unit. Fragments such as the prelude have
+// no Main.
 type Program struct {
-	Token      token.Token
+	Token token.Token
+	File  string // source the unit was parsed from
+	// Includes are the INCLUDE directives of the file. Package include
+	// merges the included procedures into Procedures and clears them.
+	Includes   []*Include
 	Procedures []*Procedure
 	Main       *Main
 }
 
 func (p *Program) TokenLiteral() string { return p.Token.Literal }
+func (p *Program) Pos() token.Position {
+	if len(p.Includes) > 0 {
+		return p.Includes[0].Pos()
+	}
+	if len(p.Procedures) > 0 {
+		return p.Procedures[0].Pos()
+	}
+	if p.Main != nil {
+		return p.Main.Pos()
+	}
+	return token.Position{File: p.File}
+}
+func (p *Program) End() token.Position {
+	if p.Main != nil {
+		return p.Main.End()
+	}
+	if len(p.Procedures) > 0 {
+		return p.Procedures[len(p.Procedures)-1].End()
+	}
+	if len(p.Includes) > 0 {
+		return p.Includes[len(p.Includes)-1].End()
+	}
+	return token.Position{File: p.File}
+}
 func (p *Program) String() string {
 	var string string
+	for _, include := range p.Includes {
+		string += include.String() + "\n"
+	}
 	for _, proc := range p.Procedures {
 		string += proc.String()
 	}
-	string += p.Main.String()
+	if p.Main != nil {
+		string += p.Main.String()
+	}
 	return string
 }
 
+// Include is an INCLUDE "path"; directive. Path is relative to the
+// including file or to a directory on the search path.
+type Include struct {
+	Token    token.Token // INCLUDE
+	Path     string      // unquoted
+	PathTok  token.Token // the quoted path
+	EndToken token.Token // ';'
+}
+
+func (i *Include) TokenLiteral() string { return i.Token.Literal }
+func (i *Include) Pos() token.Position  { return i.Token.Pos }
+func (i *Include) End() token.Position  { return i.EndToken.End() }
+func (i *Include) String() string {
+	return "INCLUDE " + strconv.Quote(i.Path) + ";"
+}
+
 type Procedure struct {
-	Token        token.Token // PROCEDURE
+	Token        token.Token // PROCEDURE or FUNCTION
+	Recursive    bool        // declared PROCEDURE RECURSIVE, may call itself
+	Returns      bool        // declared FUNCTION ... RETURNS, yields a value with RETURN
 	ProcHead     ProcHead
+	Constants    []ConstDeclaration
 	Declarations []Declaration
 	Commands     []Command
+	EndToken     token.Token // END
 }
 
 func (p *Procedure) TokenLiteral() string { return p.Token.Literal }
+func (p *Procedure) Pos() token.Position  { return p.Token.Pos }
+func (p *Procedure) End() token.Position  { return p.EndToken.End() }
 func (p *Procedure) String() string {
 	var string string
-	string += "PROCEDURE "
+	if p.Returns {
+		string += "FUNCTION "
+	} else {
+		string += "PROCEDURE "
+	}
+	if p.Recursive {
+		string += "RECURSIVE "
+	}
 	string += p.ProcHead.String()
+	if p.Returns {
+		string += " RETURNS"
+	}
 	string += " IS "
+	string += constantsString(p.Constants)
 	for _, decl := range p.Declarations {
 		string += decl.String() + ", "
 	}
@@ -70,9 +141,16 @@ type ProcHead struct {
 	Token    token.Token
 	Name     Pidentifier
 	ArgsDecl []ArgDecl
+	EndToken token.Token // ')'
+	// Requires are checked on entry to the procedure and Ensures on every
+	// return from it.
+	Requires []Contract
+	Ensures  []Contract
 }
 
 func (ph *ProcHead) TokenLiteral() string { return ph.Token.Literal }
+func (ph *ProcHead) Pos() token.Position  { return ph.Token.Pos }
+func (ph *ProcHead) End() token.Position  { return ph.EndToken.End() }
 func (ph *ProcHead) String() string {
 	var string string
 	string += ph.Name.String()
@@ -84,37 +162,92 @@ func (ph *ProcHead) String() string {
 		string += arg.String()
 	}
 	string += ")"
+	for _, c := range ph.Requires {
+		string += " " + c.String()
+	}
+	for _, c := range ph.Ensures {
+		string += " " + c.String()
+	}
 	return string
 }
 
+// Contract is a REQUIRES or ENSURES clause of a procedure head.
+type Contract struct {
+	Token     token.Token // REQUIRES or ENSURES
+	Condition BoolExpression
+}
+
+func (c *Contract) TokenLiteral() string { return c.Token.Literal }
+func (c *Contract) Pos() token.Position  { return c.Token.Pos }
+func (c *Contract) End() token.Position  { return c.Condition.End() }
+func (c *Contract) String() string       { return c.Token.Literal + " " + c.Condition.String() }
+
 type ArgDecl struct {
-	Token   token.Token
-	IsTable bool
-	Name    Pidentifier
+	Token    token.Token // the mode, T or the name, whichever comes first
+	Mode     token.Token // IN, OUT or INOUT; zero when no mode is given
+	IsTable  bool
+	Name     Pidentifier
+	Dims     []Bounds    // shape of a multi-dimensional T parameter
+	EndToken token.Token // ']' after Dims
 }
 
 func (ad *ArgDecl) TokenLiteral() string { return ad.Token.Literal }
+func (ad *ArgDecl) Pos() token.Position  { return ad.Token.Pos }
+func (ad *ArgDecl) End() token.Position {
+	if len(ad.Dims) > 0 {
+		return ad.EndToken.End()
+	}
+	return ad.Name.End()
+}
 func (ad *ArgDecl) String() string {
+	mode := ""
+	if ad.Mode.Type != "" {
+		mode = ad.Mode.Literal + " "
+	}
 	if ad.IsTable {
-		return "T " + ad.Name.String()
+		if len(ad.Dims) > 0 {
+			return mode + "T " + ad.Name.String() + "[" + boundsString(ad.Dims) + "]"
+		}
+		return mode + "T " + ad.Name.String()
 	} else {
-		return ad.Name.String()
+		return mode + ad.Name.String()
 	}
 }
 
+// Bounds is the index range From..To of one array dimension.
+type Bounds struct {
+	From Value
+	To   Value
+}
+
+func (b Bounds) String() string { return b.From.String() + ":" + b.To.String() }
+
+func boundsString(dims []Bounds) string {
+	parts := make([]string, 0, len(dims))
+	for _, d := range dims {
+		parts = append(parts, d.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
 // Holds the Main
 type Main struct {
 	Token        token.Token
+	Constants    []ConstDeclaration
 	Declarations []Declaration
-	Commands     []Command // List of commands
+	Commands     []Command   // List of commands
+	EndToken     token.Token // END
 }
 
 func (m *Main) TokenLiteral() string { return m.Token.Literal }
+func (m *Main) Pos() token.Position  { return m.Token.Pos }
+func (m *Main) End() token.Position  { return m.EndToken.End() }
 func (m *Main) String() string {
 	var s strings.Builder
 
 	// Append the program header
 	s.WriteString("PROGRAM IS\n")
+	s.WriteString(constantsString(m.Constants))
 
 	// Collect all declaration strings
 	declStrings := make([]string, 0, len(m.Declarations))
@@ -137,15 +270,57 @@ func (m *Main) String() string {
 	return s.String()
 }
 
+// ConstDeclaration names a value computed at compile time. Value may refer
+// to constants declared before it.
+type ConstDeclaration struct {
+	Name  Pidentifier
+	Value Value
+}
+
+func (cd *ConstDeclaration) TokenLiteral() string { return cd.Name.TokenLiteral() }
+func (cd *ConstDeclaration) Pos() token.Position  { return cd.Name.Pos() }
+func (cd *ConstDeclaration) End() token.Position  { return cd.Value.End() }
+func (cd *ConstDeclaration) String() string {
+	return cd.Name.String() + " = " + cd.Value.String()
+}
+
+// constantsString renders a CONST section, or nothing when there are no
+// constants.
+func constantsString(constants []ConstDeclaration) string {
+	if len(constants) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(constants))
+	for _, c := range constants {
+		parts = append(parts, c.String())
+	}
+	return "CONST " + strings.Join(parts, ", ") + ";\n"
+}
+
+// Declaration declares a variable, or an array when IsTable is set. Array
+// bounds are numbers or constant expressions. From and To bound the first
+// dimension; Inner holds the others of a multi-dimensional array.
 type Declaration struct {
 	IsTable     bool
 	Pidentifier Pidentifier
-	From        NumberLiteral
-	To          NumberLiteral
+	From        Value
+	To          Value
+	Inner       []Bounds
+	EndToken    token.Token // ']' of a table
 }
 
-func (d *Declaration) String() string {
+func (d *Declaration) Pos() token.Position { return d.Pidentifier.Pos() }
+func (d *Declaration) End() token.Position {
 	if d.IsTable {
+		return d.EndToken.End()
+	}
+	return d.Pidentifier.End()
+}
+
+func (d *Declaration) String() string {
+	if d.IsTable && len(d.Inner) > 0 {
+		return fmt.Sprintf("%s[%v:%v, %s]", d.Pidentifier.String(), d.From.String(), d.To.String(), boundsString(d.Inner))
+	} else if d.IsTable {
 		return fmt.Sprintf("%s[%v:%v]", d.Pidentifier.String(), d.From.String(), d.To.String())
 	} else {
 		return d.Pidentifier.String()
@@ -164,6 +339,13 @@ type MathExpression struct {
 
 func (me *MathExpression) expressionNode()      {}
 func (me *MathExpression) TokenLiteral() string { return me.Operator.Literal }
+func (me *MathExpression) Pos() token.Position  { return me.Left.Pos() }
+func (me *MathExpression) End() token.Position {
+	if me.Right == nil {
+		return me.Left.End()
+	}
+	return me.Right.End()
+}
 func (me *MathExpression) String() string {
 	if me.Right == nil {
 		// single operand, no operator
@@ -173,18 +355,59 @@ func (me *MathExpression) String() string {
 	return fmt.Sprintf("%s %s %s", me.Left.String(), me.Operator.Literal, me.Right.String())
 }
 
+// BoolExpression is a condition: a comparison, or conditions combined with
+// AND, OR and NOT.
+type BoolExpression interface {
+	Expression
+	boolNode()
+}
+
+// Condition is a comparison of two values.
 type Condition struct {
 	Left     Value
 	Operator token.Token
 	Right    Value
 }
 
+func (c *Condition) boolNode()            {}
 func (c *Condition) expressionNode()      {}
 func (c *Condition) TokenLiteral() string { return c.Operator.Literal }
+func (c *Condition) Pos() token.Position  { return c.Left.Pos() }
+func (c *Condition) End() token.Position  { return c.Right.End() }
 func (c *Condition) String() string {
 	return c.Left.String() + " " + c.Operator.Literal + " " + c.Right.String()
 }
 
+// LogicalExpression joins two conditions with AND or OR.
+type LogicalExpression struct {
+	Token    token.Token // AND or OR
+	Left     BoolExpression
+	Operator token.Token
+	Right    BoolExpression
+}
+
+func (le *LogicalExpression) boolNode()            {}
+func (le *LogicalExpression) expressionNode()      {}
+func (le *LogicalExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LogicalExpression) Pos() token.Position  { return le.Left.Pos() }
+func (le *LogicalExpression) End() token.Position  { return le.Right.End() }
+func (le *LogicalExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", le.Left.String(), le.Operator.Literal, le.Right.String())
+}
+
+// NotExpression negates a condition.
+type NotExpression struct {
+	Token   token.Token // NOT
+	Operand BoolExpression
+}
+
+func (ne *NotExpression) boolNode()            {}
+func (ne *NotExpression) expressionNode()      {}
+func (ne *NotExpression) TokenLiteral() string { return ne.Token.Literal }
+func (ne *NotExpression) Pos() token.Position  { return ne.Token.Pos }
+func (ne *NotExpression) End() token.Position  { return ne.Operand.End() }
+func (ne *NotExpression) String() string       { return "NOT " + ne.Operand.String() }
+
 type Value interface {
 	Expression
 	valueNode()
@@ -202,10 +425,37 @@ func (ul *UnaryExpression) expressionNode() {}
 func (ul *UnaryExpression) TokenLiteral() string {
 	return ul.Token.Literal
 }
+func (ul *UnaryExpression) Pos() token.Position { return ul.Token.Pos }
+func (ul *UnaryExpression) End() token.Position { return ul.Right.End() }
 func (ul *UnaryExpression) String() string {
 	return fmt.Sprintf("%s%s", ul.Operator.Literal, ul.Right.String())
 }
 
+// IsLiteral reports whether the expression is a negative number literal
+// rather than the negation of a computed value.
+func (ul *UnaryExpression) IsLiteral() bool {
+	_, ok := ul.Right.(*NumberLiteral)
+	return ok
+}
+
+// BinaryExpression is an arithmetic operation whose operands may themselves
+// be expressions.
+type BinaryExpression struct {
+	Token    token.Token // the operator token
+	Left     Value
+	Operator token.Token
+	Right    Value
+}
+
+func (be *BinaryExpression) valueNode()           {}
+func (be *BinaryExpression) expressionNode()      {}
+func (be *BinaryExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BinaryExpression) Pos() token.Position  { return be.Left.Pos() }
+func (be *BinaryExpression) End() token.Position  { return be.Right.End() }
+func (be *BinaryExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", be.Left.String(), be.Operator.Literal, be.Right.String())
+}
+
 type NumberLiteral struct {
 	Token token.Token //token.NUM
 	Value string      //
@@ -214,6 +464,8 @@ type NumberLiteral struct {
 func (nl *NumberLiteral) expressionNode()      {}
 func (nl *NumberLiteral) valueNode()           {}
 func (nl *NumberLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NumberLiteral) Pos() token.Position  { return nl.Token.Pos }
+func (nl *NumberLiteral) End() token.Position  { return nl.Token.End() }
 func (nl *NumberLiteral) String() string {
 	int, err := strconv.Atoi(nl.TokenLiteral())
 	if err != nil {
@@ -222,14 +474,19 @@ func (nl *NumberLiteral) String() string {
 	return fmt.Sprintf("%d", int)
 }
 
+// ProcCallCommand is a call of a PROCEDURE. Its arguments are passed like
+// those of a FunctionCall.
 type ProcCallCommand struct {
-	Token token.Token
-	Name  Pidentifier
-	Args  []Pidentifier
+	Token    token.Token
+	Name     Pidentifier
+	Args     []Value
+	EndToken token.Token // ';'
 }
 
 func (pcc *ProcCallCommand) commandNode()         {}
 func (pcc *ProcCallCommand) TokenLiteral() string { return pcc.Token.Literal }
+func (pcc *ProcCallCommand) Pos() token.Position  { return pcc.Token.Pos }
+func (pcc *ProcCallCommand) End() token.Position  { return pcc.EndToken.End() }
 func (pcc *ProcCallCommand) String() string {
 	var string string
 	string += pcc.Name.String()
@@ -245,14 +502,216 @@ func (pcc *ProcCallCommand) String() string {
 	return string
 }
 
+// FunctionCall is a call of a FUNCTION used as a value. Arguments that are
+// not plain variables are evaluated into temporaries and passed by reference
+// like any other.
+type FunctionCall struct {
+	Token    token.Token // the function name
+	Name     Pidentifier
+	Args     []Value
+	EndToken token.Token // ')'
+}
+
+func (fc *FunctionCall) expressionNode()      {}
+func (fc *FunctionCall) valueNode()           {}
+func (fc *FunctionCall) TokenLiteral() string { return fc.Token.Literal }
+func (fc *FunctionCall) Pos() token.Position  { return fc.Token.Pos }
+func (fc *FunctionCall) End() token.Position  { return fc.EndToken.End() }
+func (fc *FunctionCall) String() string {
+	args := make([]string, 0, len(fc.Args))
+	for _, arg := range fc.Args {
+		args = append(args, arg.String())
+	}
+	return fc.Name.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
+// ReturnCommand ends a FUNCTION and hands Value to the caller.
+type ReturnCommand struct {
+	Token    token.Token // RETURN
+	Value    Value
+	EndToken token.Token // ';'
+}
+
+func (rc *ReturnCommand) commandNode()         {}
+func (rc *ReturnCommand) TokenLiteral() string { return rc.Token.Literal }
+func (rc *ReturnCommand) Pos() token.Position  { return rc.Token.Pos }
+func (rc *ReturnCommand) End() token.Position  { return rc.EndToken.End() }
+func (rc *ReturnCommand) String() string       { return "RETURN " + rc.Value.String() + ";" }
+
+// AsmCommand is a block of machine instructions, ASM ... ENDASM, copied
+// into the output in place of generated code.
+type AsmCommand struct {
+	Token        token.Token // ASM
+	Instructions []AsmInstruction
+	EndToken     token.Token // ENDASM
+}
+
+func (ac *AsmCommand) commandNode()         {}
+func (ac *AsmCommand) TokenLiteral() string { return ac.Token.Literal }
+func (ac *AsmCommand) Pos() token.Position  { return ac.Token.Pos }
+func (ac *AsmCommand) End() token.Position  { return ac.EndToken.End() }
+func (ac *AsmCommand) String() string {
+	var string string
+	string += "ASM "
+	for _, ins := range ac.Instructions {
+		string += ins.String() + " "
+	}
+	string += "ENDASM"
+	return string
+}
+
+// AsmInstruction is one instruction of an ASM block with the labels in
+// front of it. Operand is a number, or a name: a label of the block for a
+// jump and an IMP variable otherwise. Labels with no Opcode mark the end of
+// the block.
+type AsmInstruction struct {
+	Labels   []Pidentifier
+	Opcode   token.Token // OPCODE
+	Operand  Value
+	EndToken token.Token // ';'
+}
+
+func (ai *AsmInstruction) TokenLiteral() string { return ai.Opcode.Literal }
+func (ai *AsmInstruction) Pos() token.Position {
+	if len(ai.Labels) > 0 {
+		return ai.Labels[0].Pos()
+	}
+	return ai.Opcode.Pos
+}
+func (ai *AsmInstruction) End() token.Position {
+	if ai.Opcode.Type == "" {
+		return ai.Labels[len(ai.Labels)-1].End()
+	}
+	return ai.EndToken.End()
+}
+func (ai *AsmInstruction) String() string {
+	var parts []string
+	for _, label := range ai.Labels {
+		parts = append(parts, label.Value+":")
+	}
+	if ai.Opcode.Type != "" {
+		parts = append(parts, ai.instruction())
+	}
+	return strings.Join(parts, " ")
+}
+
+// instruction renders the instruction without its labels.
+func (ai *AsmInstruction) instruction() string {
+	if ai.Operand == nil {
+		return ai.Opcode.Literal + ";"
+	}
+	return ai.Opcode.Literal + " " + ai.Operand.String() + ";"
+}
+
+// AssertCommand stops the program when Condition does not hold.
+type AssertCommand struct {
+	Token     token.Token // ASSERT
+	Condition BoolExpression
+	EndToken  token.Token // ';'
+}
+
+func (ac *AssertCommand) commandNode()         {}
+func (ac *AssertCommand) TokenLiteral() string { return ac.Token.Literal }
+func (ac *AssertCommand) Pos() token.Position  { return ac.Token.Pos }
+func (ac *AssertCommand) End() token.Position  { return ac.EndToken.End() }
+func (ac *AssertCommand) String() string       { return "ASSERT " + ac.Condition.String() + ";" }
+
+// BreakCommand leaves the innermost enclosing loop.
+type BreakCommand struct {
+	Token    token.Token // BREAK
+	EndToken token.Token // ';'
+}
+
+func (bc *BreakCommand) commandNode()         {}
+func (bc *BreakCommand) TokenLiteral() string { return bc.Token.Literal }
+func (bc *BreakCommand) Pos() token.Position  { return bc.Token.Pos }
+func (bc *BreakCommand) End() token.Position  { return bc.EndToken.End() }
+func (bc *BreakCommand) String() string       { return "BREAK;" }
+
+// ContinueCommand skips to the next iteration of the innermost enclosing
+// loop.
+type ContinueCommand struct {
+	Token    token.Token // CONTINUE
+	EndToken token.Token // ';'
+}
+
+func (cc *ContinueCommand) commandNode()         {}
+func (cc *ContinueCommand) TokenLiteral() string { return cc.Token.Literal }
+func (cc *ContinueCommand) Pos() token.Position  { return cc.Token.Pos }
+func (cc *ContinueCommand) End() token.Position  { return cc.EndToken.End() }
+func (cc *ContinueCommand) String() string       { return "CONTINUE;" }
+
+// CaseCommand runs the arm with a label matching Value, or ElseCommands when
+// no label does.
+type CaseCommand struct {
+	Token        token.Token // CASE
+	Value        Value
+	Arms         []CaseArm
+	ElseCommands []Command
+	EndToken     token.Token // ENDCASE
+}
+
+func (cc *CaseCommand) commandNode()         {}
+func (cc *CaseCommand) TokenLiteral() string { return cc.Token.Literal }
+func (cc *CaseCommand) Pos() token.Position  { return cc.Token.Pos }
+func (cc *CaseCommand) End() token.Position  { return cc.EndToken.End() }
+func (cc *CaseCommand) String() string {
+	var string string
+	string += "CASE " + cc.Value.String() + " OF "
+	for _, arm := range cc.Arms {
+		string += arm.String() + " "
+	}
+	if len(cc.ElseCommands) > 0 {
+		string += "ELSE "
+		string = appendCommands(string, cc.ElseCommands)
+	}
+	string += " ENDCASE"
+	return string
+}
+
+// CaseArm is one "labels: commands" alternative of a CASE.
+type CaseArm struct {
+	Labels   []CaseLabel
+	Commands []Command
+}
+
+func (ca *CaseArm) String() string {
+	labels := make([]string, 0, len(ca.Labels))
+	for _, label := range ca.Labels {
+		labels = append(labels, label.String())
+	}
+	return appendCommands(strings.Join(labels, ", ")+": ", ca.Commands)
+}
+
+// CaseLabel matches the values From..To inclusive. A single value has To
+// equal to From.
+type CaseLabel struct {
+	From    NumberLiteral
+	To      NumberLiteral
+	IsRange bool
+}
+
+func (cl *CaseLabel) TokenLiteral() string { return cl.From.TokenLiteral() }
+func (cl *CaseLabel) Pos() token.Position  { return cl.From.Pos() }
+func (cl *CaseLabel) End() token.Position  { return cl.To.End() }
+func (cl *CaseLabel) String() string {
+	if cl.IsRange {
+		return cl.From.String() + ".." + cl.To.String()
+	}
+	return cl.From.String()
+}
+
 type AssignCommand struct {
 	Identifier     Identifier  // Where will the expression be assigned to?
 	Token          token.Token // token.ASSIGN
 	MathExpression MathExpression
+	EndToken       token.Token // ';'
 }
 
 func (ac *AssignCommand) commandNode()         {}
 func (ac *AssignCommand) TokenLiteral() string { return ac.Token.Literal }
+func (ac *AssignCommand) Pos() token.Position  { return ac.Identifier.Pos() }
+func (ac *AssignCommand) End() token.Position  { return ac.EndToken.End() }
 func (ac *AssignCommand) String() string {
 	// Single-value expression
 	if ac.MathExpression.Right == nil {
@@ -273,12 +732,15 @@ func (ac *AssignCommand) String() string {
 
 type WhileCommand struct {
 	Token     token.Token //WHILE
-	Condition Condition
+	Condition BoolExpression
 	Commands  []Command
+	EndToken  token.Token // ENDWHILE
 }
 
 func (wc *WhileCommand) commandNode()         {}
 func (wc *WhileCommand) TokenLiteral() string { return wc.Token.Literal }
+func (wc *WhileCommand) Pos() token.Position  { return wc.Token.Pos }
+func (wc *WhileCommand) End() token.Position  { return wc.EndToken.End() }
 func (wc *WhileCommand) String() string {
 	var string string
 	string += "WHILE "
@@ -293,11 +755,14 @@ func (wc *WhileCommand) String() string {
 type RepeatCommand struct {
 	Token     token.Token //REPEAT
 	Commands  []Command
-	Condition Condition
+	Condition BoolExpression
+	EndToken  token.Token // ';' after the condition
 }
 
 func (rc *RepeatCommand) commandNode()         {}
 func (rc *RepeatCommand) TokenLiteral() string { return rc.Token.Literal }
+func (rc *RepeatCommand) Pos() token.Position  { return rc.Token.Pos }
+func (rc *RepeatCommand) End() token.Position  { return rc.EndToken.End() }
 func (rc *RepeatCommand) String() string {
 	var string string
 	string += "REPEAT "
@@ -315,10 +780,13 @@ type ForCommand struct {
 	From     Value
 	To       Value
 	Commands []Command
+	EndToken token.Token // ENDFOR
 }
 
 func (fc *ForCommand) commandNode()         {}
 func (fc *ForCommand) TokenLiteral() string { return fc.Token.Literal }
+func (fc *ForCommand) Pos() token.Position  { return fc.Token.Pos }
+func (fc *ForCommand) End() token.Position  { return fc.EndToken.End() }
 func (fc *ForCommand) String() string {
 	var string string
 	string += "FOR "
@@ -345,21 +813,27 @@ func appendCommands(string string, commands []Command) string {
 type ReadCommand struct {
 	Token      token.Token //READ
 	Identifier Identifier
+	EndToken   token.Token // ';'
 }
 
 func (rc *ReadCommand) commandNode()         {}
 func (rc *ReadCommand) TokenLiteral() string { return rc.Token.Literal }
+func (rc *ReadCommand) Pos() token.Position  { return rc.Token.Pos }
+func (rc *ReadCommand) End() token.Position  { return rc.EndToken.End() }
 func (rc *ReadCommand) String() string {
 	return "READ " + rc.Identifier.String() + ";"
 }
 
 type WriteCommand struct {
-	Token token.Token //WRITE
-	Value Value
+	Token    token.Token //WRITE
+	Value    Value
+	EndToken token.Token // ';'
 }
 
 func (wc *WriteCommand) commandNode()         {}
 func (wc *WriteCommand) TokenLiteral() string { return wc.Token.Literal }
+func (wc *WriteCommand) Pos() token.Position  { return wc.Token.Pos }
+func (wc *WriteCommand) End() token.Position  { return wc.EndToken.End() }
 func (wc *WriteCommand) String() string {
 	if wc.Value != nil {
 		return fmt.Sprintf("WRITE %v", wc.Value)
@@ -369,13 +843,16 @@ func (wc *WriteCommand) String() string {
 
 type IfCommand struct {
 	Token        token.Token //IF
-	Condition    Condition
+	Condition    BoolExpression
 	ThenCommands []Command
 	ElseCommands []Command
+	EndToken     token.Token // ENDIF
 }
 
 func (ic *IfCommand) commandNode()         {}
 func (ic *IfCommand) TokenLiteral() string { return ic.Token.Literal }
+func (ic *IfCommand) Pos() token.Position  { return ic.Token.Pos }
+func (ic *IfCommand) End() token.Position  { return ic.EndToken.End() }
 func (ic *IfCommand) String() string {
 	result := "IF " + ic.Condition.String() + " THEN\n"
 
@@ -400,7 +877,20 @@ type Identifier struct {
 	Token   token.Token // token.IDENT
 	Value   string
 	IsTable bool
-	Index   string
+	// Index is the index of an array access, nil for a plain variable.
+	Index Value
+	// Indices are all indices of an access, Index being the first. A
+	// multi-dimensional array takes one per dimension.
+	Indices  []Value
+	EndToken token.Token // ']' of an indexed access
+}
+
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+func (i *Identifier) End() token.Position {
+	if i.IsTable {
+		return i.EndToken.End()
+	}
+	return i.Token.End()
 }
 
 func (i *Identifier) expressionNode()      {}
@@ -408,7 +898,11 @@ func (i *Identifier) valueNode()           {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string {
 	if i.IsTable {
-		return fmt.Sprintf("%s[%s]", i.Value, i.Index)
+		indices := make([]string, len(i.Indices))
+		for k, index := range i.Indices {
+			indices[k] = index.String()
+		}
+		return fmt.Sprintf("%s[%s]", i.Value, strings.Join(indices, ", "))
 	}
 	return i.Value
 }
@@ -421,4 +915,32 @@ type Pidentifier struct {
 func (pi *Pidentifier) expressionNode()      {}
 func (pi *Pidentifier) valueNode()           {}
 func (pi *Pidentifier) TokenLiteral() string { return pi.Token.Literal }
+func (pi *Pidentifier) Pos() token.Position  { return pi.Token.Pos }
+func (pi *Pidentifier) End() token.Position  { return pi.Token.End() }
 func (pi *Pidentifier) String() string       { return pi.Value }
+
+// BadCommand stands in for a command that failed to parse. It covers the
+// tokens the parser skipped while recovering.
+type BadCommand struct {
+	Token    token.Token // first token of the broken command
+	EndToken token.Token // last token skipped
+}
+
+func (bc *BadCommand) commandNode()         {}
+func (bc *BadCommand) TokenLiteral() string { return bc.Token.Literal }
+func (bc *BadCommand) Pos() token.Position  { return bc.Token.Pos }
+func (bc *BadCommand) End() token.Position  { return bc.EndToken.End() }
+func (bc *BadCommand) String() string       { return "<bad command>" }
+
+// BadExpression stands in for a value or condition operand that failed to
+// parse, so the enclosing command can still be kept.
+type BadExpression struct {
+	Token token.Token // token where parsing failed
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) valueNode()           {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) Pos() token.Position  { return be.Token.Pos }
+func (be *BadExpression) End() token.Position  { return be.Token.End() }
+func (be *BadExpression) String() string       { return "<bad expression>" }
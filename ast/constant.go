@@ -0,0 +1,79 @@
+package ast
+
+import (
+	"strconv"
+
+	"github.com/Meduza3/imp/token"
+)
+
+// ConstantCondition computes a condition that compares number literals
+// only, such as the 1 = 0 of a REPEAT loop meant never to end. A name, even
+// of a constant, makes it not constant.
+func ConstantCondition(cond BoolExpression) (value, ok bool) {
+	switch cond := cond.(type) {
+	case *Condition:
+		left, okLeft := constantValue(cond.Left)
+		right, okRight := constantValue(cond.Right)
+		if !okLeft || !okRight {
+			return false, false
+		}
+		switch cond.Operator.Type {
+		case token.EQUALS:
+			return left == right, true
+		case token.NEQUALS:
+			return left != right, true
+		case token.LE:
+			return left < right, true
+		case token.GR:
+			return left > right, true
+		case token.LEQ:
+			return left <= right, true
+		case token.GEQ:
+			return left >= right, true
+		}
+	case *LogicalExpression:
+		left, okLeft := ConstantCondition(cond.Left)
+		right, okRight := ConstantCondition(cond.Right)
+		// One side can decide the other.
+		if cond.Operator.Type == token.AND {
+			if okLeft && !left || okRight && !right {
+				return false, true
+			}
+		} else if okLeft && left || okRight && right {
+			return true, true
+		}
+		return left, okLeft && okRight
+	case *NotExpression:
+		value, ok := ConstantCondition(cond.Operand)
+		return !value, ok
+	}
+	return false, false
+}
+
+// constantValue computes an expression of number literals. Division and modulo
+// are left out.
+func constantValue(v Value) (int, bool) {
+	switch v := v.(type) {
+	case *NumberLiteral:
+		n, err := strconv.Atoi(v.Value)
+		return n, err == nil
+	case *UnaryExpression:
+		right, ok := constantValue(v.Right)
+		return -right, ok
+	case *BinaryExpression:
+		left, okLeft := constantValue(v.Left)
+		right, okRight := constantValue(v.Right)
+		if !okLeft || !okRight {
+			return 0, false
+		}
+		switch v.Operator.Type {
+		case token.PLUS:
+			return left + right, true
+		case token.MINUS:
+			return left - right, true
+		case token.MULT:
+			return left * right, true
+		}
+	}
+	return 0, false
+}
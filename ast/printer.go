@@ -37,6 +37,13 @@ func (p *Printer) writeLine(line string) {
 }
 
 func (p *Printer) Print(program *Program) string {
+	for _, include := range program.Includes {
+		p.writeLine(include.String())
+	}
+	if len(program.Includes) > 0 {
+		p.sb.WriteString("\n")
+	}
+
 	// Print Procedures
 	for _, proc := range program.Procedures {
 		p.printProcedure(proc)
@@ -51,13 +58,21 @@ func (p *Printer) Print(program *Program) string {
 
 func (p *Printer) printProcedure(proc *Procedure) {
 	// Procedure Header
-	p.writeLine(fmt.Sprintf("PROCEDURE %s IS", proc.ProcHead.String()))
+	keyword, returns := "PROCEDURE", ""
+	if proc.Returns {
+		keyword, returns = "FUNCTION", " RETURNS"
+	}
+	if proc.Recursive {
+		keyword += " RECURSIVE"
+	}
+	p.writeLine(fmt.Sprintf("%s %s%s IS", keyword, proc.ProcHead.String(), returns))
 
 	// Indent for Declarations and BEGIN
 	p.Indent()
 
-	// Print Declarations (if any)
+	// Print Constants and Declarations (if any)
 	fmt.Println(len(proc.Declarations))
+	p.printConstants(proc.Constants)
 	if len(proc.Declarations) > 0 {
 		declStrings := []string{}
 		for _, decl := range proc.Declarations {
@@ -88,7 +103,8 @@ func (p *Printer) printMain(main *Main) {
 	p.writeLine("PROGRAM IS")
 	p.Indent()
 
-	// Print Declarations (if any)
+	// Print Constants and Declarations (if any)
+	p.printConstants(main.Constants)
 	if len(main.Declarations) > 0 {
 		declStrings := []string{}
 		for _, decl := range main.Declarations {
@@ -114,6 +130,17 @@ func (p *Printer) printMain(main *Main) {
 	p.Dedent() // Outdent after PROGRAM
 }
 
+func (p *Printer) printConstants(constants []ConstDeclaration) {
+	if len(constants) == 0 {
+		return
+	}
+	constStrings := []string{}
+	for _, c := range constants {
+		constStrings = append(constStrings, c.String())
+	}
+	p.writeLine("CONST " + strings.Join(constStrings, ", ") + ";")
+}
+
 func (p *Printer) printCommand(cmd Command) {
 	switch c := cmd.(type) {
 	case *AssignCommand:
@@ -132,6 +159,14 @@ func (p *Printer) printCommand(cmd Command) {
 		p.printWriteCommand(c)
 	case *RepeatCommand:
 		p.printRepeatCommand(c)
+	case *CaseCommand:
+		p.printCaseCommand(c)
+	case *AsmCommand:
+		p.printAsmCommand(c)
+	case *ReturnCommand, *BreakCommand, *ContinueCommand, *AssertCommand:
+		p.writeLine(c.String())
+	case *BadCommand:
+		p.writeLine("# " + c.String())
 	default:
 		p.writeLine(fmt.Sprintf("// Unhandled command type: %T", c))
 	}
@@ -176,6 +211,54 @@ func (p *Printer) printIfCommand(ic *IfCommand) {
 	p.writeLine("ENDIF")
 }
 
+func (p *Printer) printCaseCommand(cc *CaseCommand) {
+	p.writeLine(fmt.Sprintf("CASE %s OF", cc.Value.String()))
+	p.Indent()
+	for _, arm := range cc.Arms {
+		labels := []string{}
+		for _, label := range arm.Labels {
+			labels = append(labels, label.String())
+		}
+		p.writeLine(strings.Join(labels, ", ") + ":")
+		p.Indent()
+		for _, cmd := range arm.Commands {
+			p.printCommand(cmd)
+		}
+		p.Dedent()
+	}
+	p.Dedent()
+
+	if len(cc.ElseCommands) > 0 {
+		p.writeLine("ELSE")
+		p.Indent()
+		for _, cmd := range cc.ElseCommands {
+			p.printCommand(cmd)
+		}
+		p.Dedent()
+	}
+
+	p.writeLine("ENDCASE")
+}
+
+// printAsmCommand puts labels on lines of their own, outdented from the
+// instructions.
+func (p *Printer) printAsmCommand(ac *AsmCommand) {
+	p.writeLine("ASM")
+	p.Indent()
+	for _, ins := range ac.Instructions {
+		for _, label := range ins.Labels {
+			p.writeLine(label.Value + ":")
+		}
+		if ins.Opcode.Type != "" {
+			p.Indent()
+			p.writeLine(ins.instruction())
+			p.Dedent()
+		}
+	}
+	p.Dedent()
+	p.writeLine("ENDASM")
+}
+
 func (p *Printer) printWhileCommand(wc *WhileCommand) {
 	p.writeLine(fmt.Sprintf("WHILE %s DO", wc.Condition.String()))
 	p.Indent()
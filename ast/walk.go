@@ -14,6 +14,9 @@ func Walk(node Node, visit func(Node)) {
 	switch n := node.(type) {
 
 	case *Program:
+		for _, include := range n.Includes {
+			Walk(include, visit)
+		}
 		// Walk procedures
 		for _, proc := range n.Procedures {
 			Walk(proc, visit)
@@ -25,7 +28,10 @@ func Walk(node Node, visit func(Node)) {
 		// Walk procedure head (proc_head)
 		Walk(&n.ProcHead, visit)
 
-		// Walk declarations
+		// Walk constants and declarations
+		for i := range n.Constants {
+			Walk(&n.Constants[i], visit)
+		}
 		for _, decl := range n.Declarations {
 			Walk(&decl, visit)
 		}
@@ -40,14 +46,29 @@ func Walk(node Node, visit func(Node)) {
 		for i := range n.ArgsDecl {
 			Walk(&n.ArgsDecl[i], visit)
 		}
+		for i := range n.Requires {
+			Walk(&n.Requires[i], visit)
+		}
+		for i := range n.Ensures {
+			Walk(&n.Ensures[i], visit)
+		}
+
+	case *Contract:
+		Walk(n.Condition, visit)
 
 	case *ArgDecl:
-		// Contains no child nodes besides the name (Pidentifier).
-		// If you wanted to visit the name, do:
+		// The name and the bounds of a multi-dimensional T parameter.
 		Walk(&n.Name, visit)
+		for _, b := range n.Dims {
+			Walk(b.From, visit)
+			Walk(b.To, visit)
+		}
 
 	case *Main:
-		// Walk declarations
+		// Walk constants and declarations
+		for i := range n.Constants {
+			Walk(&n.Constants[i], visit)
+		}
 		for _, decl := range n.Declarations {
 			Walk(&decl, visit)
 		}
@@ -58,13 +79,21 @@ func Walk(node Node, visit func(Node)) {
 		}
 
 	case *Declaration:
-		// Pidentifier and the NumberLiterals are children.
+		// Pidentifier and the bounds are children.
 		Walk(&n.Pidentifier, visit)
 		if n.IsTable {
-			Walk(&n.From, visit)
-			Walk(&n.To, visit)
+			Walk(n.From, visit)
+			Walk(n.To, visit)
+			for _, b := range n.Inner {
+				Walk(b.From, visit)
+				Walk(b.To, visit)
+			}
 		}
 
+	case *ConstDeclaration:
+		Walk(&n.Name, visit)
+		Walk(n.Value, visit)
+
 	// --- Commands ---
 
 	case *AssignCommand:
@@ -76,12 +105,12 @@ func Walk(node Node, visit func(Node)) {
 	case *ProcCallCommand:
 		// The Pidentifier is n.Name, plus the arguments
 		Walk(&n.Name, visit)
-		for i := range n.Args {
-			Walk(&n.Args[i], visit)
+		for _, arg := range n.Args {
+			Walk(arg, visit)
 		}
 
 	case *WhileCommand:
-		Walk(&n.Condition, visit)
+		Walk(n.Condition, visit)
 		for _, cmd := range n.Commands {
 			Walk(cmd, visit)
 		}
@@ -90,7 +119,7 @@ func Walk(node Node, visit func(Node)) {
 		for _, cmd := range n.Commands {
 			Walk(cmd, visit)
 		}
-		Walk(&n.Condition, visit)
+		Walk(n.Condition, visit)
 
 	case *ForCommand:
 		Walk(&n.Iterator, visit)
@@ -103,11 +132,48 @@ func Walk(node Node, visit func(Node)) {
 	case *ReadCommand:
 		Walk(&n.Identifier, visit)
 
+	case *ReturnCommand:
+		Walk(n.Value, visit)
+
+	case *AssertCommand:
+		Walk(n.Condition, visit)
+
+	case *AsmCommand:
+		for i := range n.Instructions {
+			Walk(&n.Instructions[i], visit)
+		}
+
+	case *AsmInstruction:
+		for i := range n.Labels {
+			Walk(&n.Labels[i], visit)
+		}
+		Walk(n.Operand, visit)
+
+	case *CaseCommand:
+		Walk(n.Value, visit)
+		for i := range n.Arms {
+			for j := range n.Arms[i].Labels {
+				Walk(&n.Arms[i].Labels[j], visit)
+			}
+			for _, cmd := range n.Arms[i].Commands {
+				Walk(cmd, visit)
+			}
+		}
+		for _, cmd := range n.ElseCommands {
+			Walk(cmd, visit)
+		}
+
+	case *CaseLabel:
+		Walk(&n.From, visit)
+		if n.IsRange {
+			Walk(&n.To, visit)
+		}
+
 	case *WriteCommand:
 		Walk(n.Value, visit)
 
 	case *IfCommand:
-		Walk(&n.Condition, visit)
+		Walk(n.Condition, visit)
 		for _, cmd := range n.ThenCommands {
 			Walk(cmd, visit)
 		}
@@ -135,18 +201,49 @@ func Walk(node Node, visit func(Node)) {
 			Walk(n.Right, visit)
 		}
 
+	case *LogicalExpression:
+		Walk(n.Left, visit)
+		Walk(n.Right, visit)
+
+	case *NotExpression:
+		Walk(n.Operand, visit)
+
 	// --- Values ---
 
+	case *BinaryExpression:
+		Walk(n.Left, visit)
+		Walk(n.Right, visit)
+
+	case *UnaryExpression:
+		Walk(n.Right, visit)
+
 	case *NumberLiteral:
 		// Just a leaf node, no children to walk
 
+	case *FunctionCall:
+		Walk(&n.Name, visit)
+		for _, arg := range n.Args {
+			Walk(arg, visit)
+		}
+
 	case *Identifier:
-		// Possibly a leaf, unless you consider the index a child node.
-		// If the index is also an identifier or expression, walk it here.
+		if len(n.Indices) > 0 {
+			for _, index := range n.Indices {
+				Walk(index, visit)
+			}
+		} else if n.Index != nil {
+			Walk(n.Index, visit)
+		}
 
 	case *Pidentifier:
 		// Leaf node
 
+	case *BreakCommand, *ContinueCommand:
+		// Leaf commands
+
+	case *BadCommand, *BadExpression:
+		// Placeholders left by parser recovery
+
 	default:
 		// Unknown or unhandled node
 		fmt.Printf("Walk: unhandled node type %T\n", n)
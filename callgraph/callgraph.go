@@ -0,0 +1,240 @@
+// Package callgraph builds the graph of calls between the procedures of a
+// program and main, and finds in it the calls to procedures declared later,
+// which the language forbids, the procedures that call themselves directly
+// or through others, and those main never reaches.
+//
+// Calls are those of a PROCEDURE as a command and of a FUNCTION in an
+// expression. Calls of undeclared names are left out.
+package callgraph
+
+import (
+	"github.com/Meduza3/imp/ast"
+)
+
+// Main names the node of the main program. It cannot clash with a procedure,
+// whose names are lower case.
+const Main = "PROGRAM"
+
+// Call is one call in the source.
+type Call struct {
+	Caller, Callee *Node
+	At             *ast.Pidentifier // the called name
+}
+
+// Forward reports whether the callee is declared after the caller.
+func (c *Call) Forward() bool {
+	return c.Callee.Index > c.Caller.Index
+}
+
+// Node is a procedure or main.
+type Node struct {
+	Name  string
+	Proc  *ast.Procedure // nil for main
+	Index int            // in declaration order, main last
+	Calls []*Call        // made by the node, in source order
+	// CalledBy are the calls of the node, in the order of their callers.
+	CalledBy []*Call
+
+	component int  // strongly connected component
+	recursive bool // calls itself or is in a component of several nodes
+}
+
+// Graph is the call graph of a program.
+type Graph struct {
+	// Nodes are the procedures in declaration order, followed by main
+	// when the program has one.
+	Nodes      []*Node
+	byName     map[string]*Node
+	components int // strongly connected components numbered so far
+}
+
+// Build returns the call graph of program. Only the first of procedures
+// declared under the same name is kept.
+func Build(program *ast.Program) *Graph {
+	g := &Graph{byName: map[string]*Node{}}
+	var bodies []ast.Node
+	for _, proc := range program.Procedures {
+		if proc == nil || g.byName[proc.ProcHead.Name.Value] != nil {
+			continue
+		}
+		g.add(&Node{Name: proc.ProcHead.Name.Value, Proc: proc})
+		bodies = append(bodies, proc)
+	}
+	if program.Main != nil {
+		g.add(&Node{Name: Main})
+		bodies = append(bodies, program.Main)
+	}
+	for i, body := range bodies {
+		caller := g.Nodes[i]
+		ast.Walk(body, func(n ast.Node) {
+			var name *ast.Pidentifier
+			switch n := n.(type) {
+			case *ast.ProcCallCommand:
+				name = &n.Name
+			case *ast.FunctionCall:
+				name = &n.Name
+			default:
+				return
+			}
+			callee := g.byName[name.Value]
+			if callee == nil || callee.Proc == nil {
+				return
+			}
+			call := &Call{Caller: caller, Callee: callee, At: name}
+			caller.Calls = append(caller.Calls, call)
+			callee.CalledBy = append(callee.CalledBy, call)
+			if callee == caller {
+				caller.recursive = true
+			}
+		})
+	}
+	g.findComponents()
+	return g
+}
+
+func (g *Graph) add(n *Node) {
+	n.Index = len(g.Nodes)
+	g.Nodes = append(g.Nodes, n)
+	g.byName[n.Name] = n
+}
+
+// Lookup returns the node of the procedure called name, or of main for
+// Main, and nil when there is none.
+func (g *Graph) Lookup(name string) *Node {
+	return g.byName[name]
+}
+
+// Reachable returns the names of the procedures main calls, directly or
+// through other procedures.
+func (g *Graph) Reachable() map[string]bool {
+	reached := map[string]bool{}
+	main := g.Lookup(Main)
+	if main == nil {
+		return reached
+	}
+	work := []*Node{main}
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, call := range n.Calls {
+			if !reached[call.Callee.Name] {
+				reached[call.Callee.Name] = true
+				work = append(work, call.Callee)
+			}
+		}
+	}
+	return reached
+}
+
+// Unreachable returns the procedures main never calls, in declaration order.
+func (g *Graph) Unreachable() []*Node {
+	reached := g.Reachable()
+	var nodes []*Node
+	for _, n := range g.Nodes {
+		if n.Proc != nil && !reached[n.Name] {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// ForwardCalls returns the calls of procedures declared after their caller,
+// in source order of the callers.
+func (g *Graph) ForwardCalls() []*Call {
+	var calls []*Call
+	for _, n := range g.Nodes {
+		for _, call := range n.Calls {
+			if call.Forward() {
+				calls = append(calls, call)
+			}
+		}
+	}
+	return calls
+}
+
+// Recursive reports whether n can call itself, directly or through other
+// procedures.
+func (n *Node) Recursive() bool {
+	return n.recursive
+}
+
+// Recursive reports whether a call from caller to callee can lead back to
+// caller: a procedure calling itself, or one of mutually recursive
+// procedures calling another.
+func (g *Graph) Recursive(caller, callee string) bool {
+	if caller == callee {
+		return true
+	}
+	a, b := g.Lookup(caller), g.Lookup(callee)
+	return a != nil && b != nil && a.component == b.component
+}
+
+// Cycles returns the groups of procedures that call one another in a cycle,
+// each in declaration order. A procedure that only calls itself is a group
+// of its own.
+func (g *Graph) Cycles() [][]*Node {
+	groups := map[int][]*Node{}
+	var order []int
+	for _, n := range g.Nodes {
+		if !n.Recursive() {
+			continue
+		}
+		if groups[n.component] == nil {
+			order = append(order, n.component)
+		}
+		groups[n.component] = append(groups[n.component], n)
+	}
+	cycles := make([][]*Node, len(order))
+	for i, c := range order {
+		cycles[i] = groups[c]
+	}
+	return cycles
+}
+
+// findComponents numbers the strongly connected components of the graph
+// with Tarjan's algorithm.
+func (g *Graph) findComponents() {
+	index := map[*Node]int{}
+	low := map[*Node]int{}
+	onStack := map[*Node]bool{}
+	var stack []*Node
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		index[n] = len(index)
+		low[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, call := range n.Calls {
+			m := call.Callee
+			if _, seen := index[m]; !seen {
+				visit(m)
+				low[n] = min(low[n], low[m])
+			} else if onStack[m] {
+				low[n] = min(low[n], index[m])
+			}
+		}
+		if low[n] != index[n] {
+			return
+		}
+		var component []*Node
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[m] = false
+			m.component = g.components
+			component = append(component, m)
+			if m == n {
+				break
+			}
+		}
+		for _, m := range component {
+			m.recursive = m.recursive || len(component) > 1
+		}
+		g.components++
+	}
+	for _, n := range g.Nodes {
+		if _, seen := index[n]; !seen {
+			visit(n)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package callgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDOT writes the graph in the DOT language of Graphviz. Each pair of
+// caller and callee gets one edge. Main is drawn as a box, recursive
+// procedures with a double border and unreachable ones greyed out; calls to
+// procedures declared later are red.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	reached := g.Reachable()
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printf("digraph calls {\n")
+	for _, n := range g.Nodes {
+		var attrs []string
+		switch {
+		case n.Proc == nil:
+			attrs = append(attrs, "shape=box")
+		case n.Recursive():
+			attrs = append(attrs, "peripheries=2")
+		}
+		if n.Proc != nil && !reached[n.Name] {
+			attrs = append(attrs, "style=dashed", "color=gray")
+		}
+		if len(attrs) == 0 {
+			printf("\t%q;\n", n.Name)
+		} else {
+			printf("\t%q [%s];\n", n.Name, strings.Join(attrs, ", "))
+		}
+	}
+	for _, n := range g.Nodes {
+		seen := map[*Node]bool{}
+		for _, call := range n.Calls {
+			if seen[call.Callee] {
+				continue
+			}
+			seen[call.Callee] = true
+			if call.Forward() {
+				printf("\t%q -> %q [color=red];\n", n.Name, call.Callee.Name)
+			} else {
+				printf("\t%q -> %q;\n", n.Name, call.Callee.Name)
+			}
+		}
+	}
+	printf("}\n")
+	return err
+}
+
+type jsonGraph struct {
+	Nodes []jsonNode `json:"nodes"`
+	Calls []jsonCall `json:"calls"`
+}
+
+type jsonNode struct {
+	Name      string `json:"name"`
+	Main      bool   `json:"main,omitempty"`
+	Recursive bool   `json:"recursive"`
+	Reachable bool   `json:"reachable"`
+}
+
+type jsonCall struct {
+	Caller  string `json:"caller"`
+	Callee  string `json:"callee"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Forward bool   `json:"forward"`
+}
+
+// MarshalJSON encodes the graph as its nodes, with whether each is recursive
+// and reachable from main, and every call with its position.
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	reached := g.Reachable()
+	out := jsonGraph{Nodes: []jsonNode{}, Calls: []jsonCall{}}
+	for _, n := range g.Nodes {
+		out.Nodes = append(out.Nodes, jsonNode{
+			Name:      n.Name,
+			Main:      n.Proc == nil,
+			Recursive: n.Recursive(),
+			Reachable: n.Proc == nil || reached[n.Name],
+		})
+		for _, call := range n.Calls {
+			pos := call.At.Pos()
+			out.Calls = append(out.Calls, jsonCall{
+				Caller:  n.Name,
+				Callee:  call.Callee.Name,
+				File:    pos.File,
+				Line:    pos.Line,
+				Column:  pos.Column,
+				Forward: call.Forward(),
+			})
+		}
+	}
+	return json.Marshal(out)
+}
+
+// WriteJSON writes the graph as indented JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -52,6 +52,25 @@ var definitions = map[Opcode]*Definition{
 	HALT:   {"HALT", 0},
 }
 
+// Operands returns the number of operands op takes, and false if op is not
+// an instruction of the machine.
+func Operands(op string) (int, bool) {
+	def, ok := definitions[op]
+	if !ok {
+		return 0, false
+	}
+	return def.NumOperands, true
+}
+
+// IsJump reports whether op jumps by the offset in its operand.
+func IsJump(op Opcode) bool {
+	switch op {
+	case JUMP, JPOS, JZERO, JNEG:
+		return true
+	}
+	return false
+}
+
 func Lookup(op byte) (*Definition, error) {
 	def, ok := definitions[Opcode(op)]
 	if !ok {
@@ -2,16 +2,39 @@ package main_test
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/callgraph"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/include"
+	"github.com/Meduza3/imp/lexer"
+	"github.com/Meduza3/imp/lint"
+	"github.com/Meduza3/imp/parser"
+	"github.com/Meduza3/imp/sema"
+	"github.com/Meduza3/imp/tac"
+	"github.com/Meduza3/imp/token"
+	"github.com/Meduza3/imp/translator"
 )
 
 func testAssembly(t *testing.T, inputCode string, expectedOutputNumbers []int, userInput string) {
+	t.Helper()
+	testAssemblyFlags(t, nil, inputCode, expectedOutputNumbers, userInput)
+}
+
+// testAssemblyFlags is testAssembly with extra command-line flags for the
+// compiler.
+func testAssemblyFlags(t *testing.T, flags []string, inputCode string, expectedOutputNumbers []int, userInput string) {
 	t.Helper()
 	// TODO: implement the equivalent logic:
 	// 1) Write inputCode to a file
@@ -35,7 +58,7 @@ func testAssembly(t *testing.T, inputCode string, expectedOutputNumbers []int, u
 	if err != nil {
 		t.Fatalf("failed to create file")
 	}
-	compilerCmd := exec.Command("./bin/main", file.Name(), file2.Name())
+	compilerCmd := exec.Command("./bin/main", append(flags, file.Name(), file2.Name())...)
 
 	if err := compilerCmd.Start(); err != nil {
 		t.Fatalf("failed to execute compiler: %v", err)
@@ -102,6 +125,175 @@ func extractNumbers(output string) []int {
 	return numbers
 }
 
+// compileDiagnostics runs the front end and the translator in process and
+// returns the diagnostics of the first phase that failed.
+func compileDiagnostics(t *testing.T, path string) diag.List {
+	t.Helper()
+	loader := include.NewLoader()
+	program, err := loader.Load(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	if errs := loader.Errors(); errs.HasErrors() {
+		return errs
+	}
+	diags := sema.Check(program)
+	if diags.HasErrors() {
+		return diags
+	}
+	g := tac.NewGenerator()
+	g.Generate(program)
+	if g.Errors.HasErrors() {
+		return append(diags, g.Errors...)
+	}
+	tr := translator.New(*g.SymbolTable)
+	tr.Translate(tac.MergeLabelOnlyInstructions(g.Instructions))
+	return append(diags, tr.Errors()...)
+}
+
+// diagnosticCase is a file with the codes of the diagnostics it gives, in
+// source order, and the lines they are reported on.
+type diagnosticCase struct {
+	file  string
+	codes []string
+	lines []int
+}
+
+// checkDiagnostics compiles the file of each case, found in dir, and compares
+// its diagnostics with those the case expects.
+func checkDiagnostics(t *testing.T, dir string, compile func(t *testing.T, path string) diag.List, cases []diagnosticCase) {
+	t.Helper()
+	for _, tt := range cases {
+		diags := compile(t, filepath.Join(dir, tt.file))
+		diags.Sort()
+		if len(diags) != len(tt.codes) {
+			t.Errorf("%s: expected %d diagnostics, got %v", tt.file, len(tt.codes), diags.Strings())
+			continue
+		}
+		for i, d := range diags {
+			if d.Code != tt.codes[i] || d.Span.Start.Line != tt.lines[i] {
+				t.Errorf("%s: expected %s at line %d, got %s", tt.file, tt.codes[i], tt.lines[i], d)
+			}
+		}
+	}
+}
+
+func TestErrorDiagnostics(t *testing.T) {
+	tests := []struct {
+		file string
+		code string
+		line int
+	}{
+		{"resources/testy/error1.imp", diag.ErrArrayMisuse, 5},
+		{"resources/testy/error2.imp", diag.ErrUninitialized, 6},
+		{"resources/testy/error3.imp", diag.ErrArrayMisuse, 5},
+		{"resources/testy/error4.imp", diag.ErrArrayMisuse, 5},
+		{"resources/testy/error5.imp", diag.ErrArgumentKind, 13},
+		{"resources/testy/error6.imp", diag.ErrRedeclared, 3},
+		{"resources/testy/error7.imp", diag.ErrRecursiveCall, 6},
+		{"resources/testy/error8.imp", diag.ErrIteratorModified, 8},
+	}
+	for _, tt := range tests {
+		errs := compileDiagnostics(t, tt.file)
+		if len(errs) == 0 {
+			t.Errorf("%s: expected %s, got no diagnostics", tt.file, tt.code)
+			continue
+		}
+		errs.Sort()
+		d := errs[0]
+		if d.Code != tt.code || d.Span.Start.Line != tt.line || d.Span.Start.File != tt.file {
+			t.Errorf("%s: expected %s at line %d, got %s", tt.file, tt.code, tt.line, d)
+		}
+	}
+}
+
+func TestSuggestedFixes(t *testing.T) {
+	const src = "PROCEDURE p(n) IS BEGIN\np(n); END PROGRAM IS a BEGIN\nIF a = 1 a := 2; ENDIF\na := 1\nWRITE a; END"
+	p := parser.New(lexer.NewFile("fix.imp", src))
+	program := p.ParseProgram()
+	diags := append(p.Errors(), sema.Check(program)...)
+	diags.Sort()
+	want := []struct {
+		code, at, replacement string
+	}{
+		{diag.ErrRecursiveCall, "fix.imp:1:11", "RECURSIVE "},
+		{diag.ErrSyntax, "fix.imp:3:9", " THEN"},
+		{diag.ErrSyntax, "fix.imp:4:7", ";"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %v", len(want), diags.Strings())
+	}
+	for i, w := range want {
+		d := diags[i]
+		if d.Code != w.code || d.Fix == nil || d.Fix.Span.Start.String() != w.at || d.Fix.Replacement != w.replacement {
+			t.Errorf("expected %s with a fix inserting %q at %s, got %s with %+v", w.code, w.replacement, w.at, d, d.Fix)
+		}
+	}
+
+	var out strings.Builder
+	diag.Fprint(&out, diags[2:], diag.Sources{"fix.imp": src})
+	if !strings.Contains(out.String(), "help: insert ';': \";\"") {
+		t.Errorf("expected a help line, got:\n%s", out.String())
+	}
+}
+
+func TestPositions(t *testing.T) {
+	const src = "PROGRAM IS a BEGIN\n  a := 12 + a;\nEND"
+	l := lexer.NewFile("pos.imp", src)
+	var got []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		got = append(got, tok.Literal+"@"+tok.Pos.String())
+	}
+	want := []string{
+		"PROGRAM@pos.imp:1:1", "IS@pos.imp:1:9", "a@pos.imp:1:12", "BEGIN@pos.imp:1:14",
+		"a@pos.imp:2:3", ":=@pos.imp:2:5", "12@pos.imp:2:8", "+@pos.imp:2:11", "a@pos.imp:2:13", ";@pos.imp:2:14",
+		"END@pos.imp:3:1",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected tokens %v, got %v", want, got)
+	}
+
+	p := parser.New(lexer.NewFile("pos.imp", src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	assign := program.Main.Commands[0].(*ast.AssignCommand)
+	spans := []struct {
+		node       ast.Node
+		start, end string
+	}{
+		{program, "pos.imp:1:1", "pos.imp:3:4"},
+		{assign, "pos.imp:2:3", "pos.imp:2:15"},
+		{&assign.Identifier, "pos.imp:2:3", "pos.imp:2:4"},
+		{&assign.MathExpression, "pos.imp:2:8", "pos.imp:2:14"},
+		{assign.MathExpression.Left, "pos.imp:2:8", "pos.imp:2:10"},
+	}
+	for _, tt := range spans {
+		if start, end := tt.node.Pos().String(), tt.node.End().String(); start != tt.start || end != tt.end {
+			t.Errorf("%s: expected span %s-%s, got %s-%s", tt.node, tt.start, tt.end, start, end)
+		}
+	}
+}
+
+func TestPrelude(t *testing.T) {
+	testAssembly(t, "PROGRAM IS a, b BEGIN a := 6; b := 7; a := a * b; WRITE a; END", []int{42}, "")
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"variable.imp":  "PROGRAM IS a,\nbuilt_in_left BEGIN a := 1; WRITE a; END",
+		"procedure.imp": "PROCEDURE\nbuilt_in_mult(x) IS BEGIN x := 1; END PROGRAM IS BEGIN END",
+		"call.imp":      "PROGRAM IS a BEGIN a := 6;\nbuilt_in_mult(a); WRITE a; END",
+		"read.imp":      "PROGRAM IS a BEGIN\na := built_in_result; WRITE a; END",
+	})
+	checkDiagnostics(t, dir, compileDiagnostics, []diagnosticCase{
+		{"variable.imp", []string{diag.ErrReserved}, []int{2}},
+		{"procedure.imp", []string{diag.ErrReserved}, []int{2}},
+		{"call.imp", []string{diag.ErrUndefinedProc}, []int{2}},
+		{"read.imp", []string{diag.ErrUndeclared}, []int{2}},
+	})
+}
+
 func TestIfStatements(t *testing.T) {
 	// t.Skip()
 	cases := []struct {
@@ -210,6 +402,8 @@ func TestAssignmentStatements(t *testing.T) {
 		{"PROGRAM IS x[-5:5] BEGIN x[0] := 126; WRITE x[0]; END", []int{126}},
 		{"PROGRAM IS n, x[5:10] BEGIN n := 7; x[n] := 127; WRITE x[n]; END", []int{127}},
 		{"PROGRAM IS x[5:10], n BEGIN n := 7; x[n] := 128; WRITE x[n]; END", []int{128}},
+		{"PROGRAM IS x[-5:5], n BEGIN n := 2; x[-n] := 129; x[n + 1] := 130; WRITE x[-2]; WRITE x[3]; END", []int{129, 130}},
+		{"PROGRAM IS x[0:3], n BEGIN n := 1; x[n] := 3; x[x[n]] := 131; WRITE x[3]; END", []int{131}},
 	}
 
 	for _, tt := range cases {
@@ -269,6 +463,701 @@ func TestArithmeticOperations(t *testing.T) {
 	}
 }
 
+func TestExpressions(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"PROGRAM IS a, b, c, d, e BEGIN b := 4; c := 6; d := 3; e := 11; a := (b + c) * d - e % 3; WRITE a; END", []int{28}},
+		{"PROGRAM IS a BEGIN a := 2 + 3 * 4; WRITE a; END", []int{14}},
+		{"PROGRAM IS a BEGIN a := 10 - 4 - 3; WRITE a; END", []int{3}},
+		{"PROGRAM IS a BEGIN a := 2 * (3 + 4) * 5 / 7; WRITE a; END", []int{10}},
+		{"PROGRAM IS a, b, c BEGIN b := 4; c := 6; a := -(b - c) * -b; WRITE a; END", []int{-8}},
+		{"PROGRAM IS a, t[0:9] BEGIN a := 2; t[a + 1] := 42; WRITE t[9 - 6]; END", []int{42}},
+		{"PROGRAM IS a, b BEGIN a := 3; b := 4; IF a * 2 > b + 1 THEN WRITE 1; ELSE WRITE 0; ENDIF END", []int{1}},
+		{"PROGRAM IS a BEGIN a := 5; WHILE a - 2 > 0 DO a := a - 1; ENDWHILE WRITE a; END", []int{2}},		{"PROGRAM IS a, b BEGIN a := 6; b := 7; WRITE a * b; WRITE -(a - b) + 1; END", []int{42, 2}},
+		{"PROGRAM IS a, n, i BEGIN a := 1; n := 5; FOR i FROM a + 1 TO n - 1 DO WRITE i; ENDFOR END", []int{2, 3, 4}},
+		{"PROGRAM IS n, i BEGIN n := 2; FOR i FROM n * 2 DOWNTO n / 2 DO WRITE i * 10; ENDFOR END", []int{40, 30, 20, 10}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestBooleanConditions(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"PROGRAM IS a, b, c BEGIN a := 1; b := 2; c := 3; IF a < b AND b < c THEN WRITE 1; ELSE WRITE 0; ENDIF END", []int{1}},
+		{"PROGRAM IS a, b, c BEGIN a := 1; b := 2; c := 3; IF a > b AND b < c THEN WRITE 1; ELSE WRITE 0; ENDIF END", []int{0}},
+		{"PROGRAM IS a, b, c BEGIN a := 1; b := 2; c := 3; IF a > b OR b < c THEN WRITE 1; ELSE WRITE 0; ENDIF END", []int{1}},
+		{"PROGRAM IS a, b BEGIN a := 1; b := 2; IF NOT a < b THEN WRITE 1; ELSE WRITE 0; ENDIF END", []int{0}},
+		{"PROGRAM IS a, b, c BEGIN a := 1; b := 2; c := 3; IF NOT (a > b OR c = 4) AND (a + b) = c THEN WRITE 1; ELSE WRITE 0; ENDIF END", []int{1}},
+		{"PROGRAM IS d BEGIN d := 0; IF d != 0 AND 10 / d > 1 THEN WRITE 5; ELSE WRITE 6; ENDIF END", []int{6}},
+		{"PROGRAM IS d BEGIN d := 0; WHILE d < 10 AND d * d < 20 DO d := d + 1; ENDWHILE WRITE d; END", []int{5}},
+		{"PROGRAM IS d BEGIN d := 5; REPEAT d := d - 1; UNTIL d = 0 OR d = 2; WRITE d; END", []int{2}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"PROCEDURE RECURSIVE fact(n, r) IS m, s BEGIN IF n = 0 THEN r := 1; ELSE m := n - 1; fact(m, s); r := n * s; ENDIF END PROGRAM IS n, r BEGIN n := 10; fact(n, r); WRITE r; END", []int{3628800}},
+		{"PROCEDURE RECURSIVE fib(n, r) IS a, b, m BEGIN IF n < 2 THEN r := n; ELSE m := n - 1; fib(m, a); m := n - 2; fib(m, b); r := a + b; ENDIF END PROGRAM IS n, r BEGIN n := 12; fib(n, r); WRITE r; END", []int{144}},
+		{"PROCEDURE RECURSIVE f(T a, n) IS loc[3:3], m BEGIN IF n > 0 THEN loc[3] := n; m := n - 1; f(loc, m); a[3] := a[3] + loc[3]; ENDIF END PROGRAM IS t[3:3], k BEGIN t[3] := 0; k := 4; f(t, k); WRITE t[3]; END", []int{10}},
+		{"PROCEDURE RECURSIVE down(n) IS m BEGIN IF n > 0 THEN WRITE n; m := n - 1; down(m); WRITE n; ENDIF END PROGRAM IS n BEGIN n := 3; down(n); END", []int{3, 2, 1, 1, 2, 3}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"FUNCTION square(x) RETURNS IS BEGIN RETURN x * x; END PROGRAM IS n BEGIN n := 7; WRITE square(n); END", []int{49}},
+		{"FUNCTION square(x) RETURNS IS BEGIN RETURN x * x; END PROGRAM IS n, r BEGIN n := 3; r := square(n) + square(n + 1); WRITE r; END", []int{25}},
+		{"FUNCTION max(a, b) RETURNS IS BEGIN IF a > b THEN RETURN a; ENDIF RETURN b; END PROGRAM IS n BEGIN n := 4; IF max(n, 9) = 9 THEN WRITE max(n, 2); ENDIF END", []int{4}},
+		{"FUNCTION RECURSIVE fact(n) RETURNS IS BEGIN IF n = 0 THEN RETURN 1; ENDIF RETURN n * fact(n - 1); END PROGRAM IS n BEGIN n := 6; WRITE fact(n); END", []int{720}},
+		{"FUNCTION first(T t) RETURNS IS BEGIN RETURN t[0]; END PROGRAM IS t[0:2], r BEGIN t[0] := 11; t[1] := 1; r := t[first(t) - 10] + first(t); WRITE r; END", []int{12}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestLoopControl(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"PROGRAM IS n BEGIN n := 0; FOR i FROM 1 TO 10 DO IF i = 4 THEN BREAK; ENDIF n := i; ENDFOR WRITE n; END", []int{3}},
+		{"PROGRAM IS s BEGIN s := 0; FOR i FROM 10 DOWNTO 1 DO IF i % 2 = 0 THEN CONTINUE; ENDIF s := s + i; ENDFOR WRITE s; END", []int{25}},
+		{"PROGRAM IS i, s BEGIN i := 0; s := 0; WHILE i < 100 DO i := i + 1; IF i % 3 != 0 THEN CONTINUE; ENDIF IF i > 10 THEN BREAK; ENDIF s := s + i; ENDWHILE WRITE s; WRITE i; END", []int{18, 12}},
+		{"PROGRAM IS i, s BEGIN i := 0; s := 0; REPEAT i := i + 1; IF i = 2 THEN CONTINUE; ENDIF FOR k FROM 1 TO 5 DO IF k > 2 THEN BREAK; ENDIF s := s + 1; ENDFOR UNTIL i = 5; WRITE s; WRITE i; END", []int{8, 5}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestCase(t *testing.T) {
+	// Forty consecutive labels make a jump table cheaper than comparisons.
+	var dense strings.Builder
+	dense.WriteString("PROGRAM IS x, r BEGIN READ x; CASE x OF ")
+	for i := 0; i < 40; i++ {
+		if i == 20 {
+			continue
+		}
+		dense.WriteString(strconv.Itoa(i) + ": r := " + strconv.Itoa(i*10) + "; ")
+	}
+	dense.WriteString("ELSE r := 1; ENDCASE WRITE r; END")
+
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+		userInput      string
+	}{
+		{"PROGRAM IS x BEGIN x := 3; CASE x OF 1: WRITE 10; 2..4, 7: WRITE 20; ELSE WRITE 30; ENDCASE END", []int{20}, ""},
+		{"PROGRAM IS x BEGIN x := 7; CASE x OF 1: WRITE 10; 2..4, 7: WRITE 20; ELSE WRITE 30; ENDCASE END", []int{20}, ""},
+		{"PROGRAM IS x BEGIN x := 5; CASE x OF 1: WRITE 10; 2..4, 7: WRITE 20; ELSE WRITE 30; ENDCASE WRITE 40; END", []int{30, 40}, ""},
+		{"PROGRAM IS x BEGIN x := 0; CASE x OF 1: WRITE 10; ENDCASE WRITE 40; END", []int{40}, ""},
+		{"PROGRAM IS x BEGIN x := -3; CASE x - 1 OF -5..-4: WRITE 10; -1..1: WRITE 20; ENDCASE END", []int{10}, ""},
+		{dense.String(), []int{170}, "17\n"},
+		{dense.String(), []int{390}, "39\n"},
+		{dense.String(), []int{1}, "20\n"},
+		{dense.String(), []int{1}, "40\n"},
+		{dense.String(), []int{1}, "-1\n"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, tt.userInput)
+		})
+	}
+}
+
+func TestConstants(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"PROGRAM IS CONST n = 100, m = n * 2; BEGIN WRITE n; WRITE m; END", []int{100, 200}},
+		{"PROGRAM IS CONST n = 4; t[0:n], s BEGIN FOR i FROM 0 TO n DO t[i] := i; ENDFOR s := t[n] + t[n - 1]; WRITE s; END", []int{7}},
+		{"PROGRAM IS CONST lo = -2, hi = lo + 5, q = -7 / 2, r = -7 % 3; t[lo:hi] BEGIN t[lo] := q; t[hi] := r; WRITE t[-2]; WRITE t[3]; END", []int{-4, 2}},
+		{"PROCEDURE inc(x) IS BEGIN x := x + 1; END PROGRAM IS CONST n = 5; BEGIN inc(n); WRITE n; END", []int{5}},
+		{"PROCEDURE p(T t) IS CONST last = 3; BEGIN t[last] := last * last; END PROGRAM IS t[0:3] BEGIN p(t); WRITE t[3]; END", []int{9}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestMultiDimArrays(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{"PROGRAM IS m[0:2, 0:3], i, j BEGIN FOR i FROM 0 TO 2 DO FOR j FROM 0 TO 3 DO m[i, j] := i * 10 + j; ENDFOR ENDFOR WRITE m[2, 3]; WRITE m[1, 0]; i := 1; j := 2; WRITE m[i, j]; END", []int{23, 10, 12}},
+		{"PROGRAM IS n[-1:1, 2:3, 0:1], i, j BEGIN n[-1, 2, 0] := 5; n[1, 3, 1] := 7; i := 0; n[i, 3, i] := 9; j := 1; WRITE n[-1, 2, 0]; WRITE n[j, 3, j]; WRITE n[0, 3, 0]; END", []int{5, 7, 9}},
+		{"PROCEDURE sum(T m[0:2, 0:3], s) IS i, j BEGIN s := 0; FOR i FROM 0 TO 2 DO FOR j FROM 0 TO 3 DO s := s + m[i, j]; ENDFOR ENDFOR END PROGRAM IS m[0:2, 0:3], s, i, j BEGIN FOR i FROM 0 TO 2 DO FOR j FROM 0 TO 3 DO m[i, j] := i + j; ENDFOR ENDFOR sum(m, s); WRITE s; END", []int{30}},
+		{"PROCEDURE fill(T m[0:1, 1:3], v) IS j BEGIN m[0, 1] := v; j := 3; m[1, j] := v + 1; END PROGRAM IS a[5:6, 0:2], v BEGIN v := 100; a[5, 1] := 0; a[6, 2] := 0; fill(a, v); WRITE a[5, 0]; WRITE a[6, 2]; WRITE a[5, 1]; END", []int{100, 101, 0}},
+		{"PROGRAM IS CONST n = 2; m[1:n, 1:n], i BEGIN i := n; m[i, 1] := 4; m[1, i] := 6; WRITE m[2, 1]; WRITE m[1, 2]; END", []int{4, 6}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+// writeFiles creates files, given by name relative to dir, with their
+// contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"lib/math.imp": "INCLUDE \"util.imp\"; FUNCTION sq(x) RETURNS IS BEGIN RETURN x * x; END",
+		"lib/util.imp": "PROCEDURE twice(x) IS BEGIN x := x + x; END",
+		"cycle/a.imp":  "INCLUDE \"b.imp\"; PROCEDURE pa(x) IS BEGIN x := 1; END",
+		"cycle/b.imp":  "INCLUDE \"a.imp\";\nPROCEDURE pb(x) IS BEGIN x := 2; END",
+		"bad.imp":      "PROCEDURE pc(x) IS\nBEGIN\n  x := ;\nEND",
+		"missing.imp":  "INCLUDE \"lib/none.imp\"; PROGRAM IS BEGIN WRITE 1; END",
+		"errors.imp":   "INCLUDE \"cycle/a.imp\"; INCLUDE \"bad.imp\"; PROGRAM IS BEGIN WRITE 1; END",
+	})
+
+	lib := filepath.Join(dir, "lib")
+	code := "INCLUDE \"" + filepath.Join(lib, "math.imp") + "\"; INCLUDE \"" + filepath.Join(lib, "util.imp") + "\"; " +
+		"PROGRAM IS a BEGIN a := sq(7); twice(a); WRITE a; END"
+	testAssembly(t, code, []int{98}, "")
+
+	tests := []struct {
+		file  string
+		codes []string
+		at    []string
+	}{
+		{"missing.imp", []string{diag.ErrInclude}, []string{"missing.imp:1:9"}},
+		{"errors.imp", []string{diag.ErrSyntax, diag.ErrInclude}, []string{"bad.imp:3:8", "b.imp:1:9"}},
+	}
+	for _, tt := range tests {
+		errs := compileDiagnostics(t, filepath.Join(dir, tt.file))
+		errs.Sort()
+		if len(errs) != len(tt.codes) {
+			t.Errorf("%s: expected %d diagnostics, got %v", tt.file, len(tt.codes), errs.Strings())
+			continue
+		}
+		for i, d := range errs {
+			if d.Code != tt.codes[i] || !strings.HasSuffix(d.Span.Start.String(), tt.at[i]) {
+				t.Errorf("%s: expected %s at %s, got %s", tt.file, tt.codes[i], tt.at[i], d)
+			}
+		}
+	}
+}
+
+func TestStdlib(t *testing.T) {
+	const math = "INCLUDE \"std/math.imp\"; "
+	const array = "INCLUDE \"std/array.imp\"; "
+	// setup fills t[-2:7] with unsorted values summing to 30.
+	const setup = "lo := -2; hi := 7; t[-2] := 5; t[-1] := -3; t[0] := 9; t[1] := 0; t[2] := 5; t[3] := 12; t[4] := -8; t[5] := 1; t[6] := 7; t[7] := 2; "
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+	}{
+		{math + "PROGRAM IS a, b, r BEGIN a := -5; r := abs(a); WRITE r; a := 6; r := abs(a); WRITE r; END", []int{5, 6}},
+		{math + "PROGRAM IS a, b, r BEGIN a := -5; b := 4; r := min(a, b); WRITE r; r := max(a, b); WRITE r; END", []int{-5, 4}},
+		{math + "PROGRAM IS a, b, r BEGIN a := -12; b := 18; r := gcd(a, b); WRITE r; a := 0; b := 0; r := gcd(a, b); WRITE r; a := 17; b := 5; r := gcd(a, b); WRITE r; END", []int{6, 0, 1}},
+		{math + "PROGRAM IS a, b, r BEGIN a := -12; b := 18; r := lcm(a, b); WRITE r; a := 0; r := lcm(a, b); WRITE r; END", []int{36, 0}},
+		{math + "PROGRAM IS a, b, r BEGIN a := 3; b := 13; r := pow(a, b); WRITE r; a := -2; b := 5; r := pow(a, b); WRITE r; b := 0; r := pow(a, b); WRITE r; b := -1; r := pow(a, b); WRITE r; END", []int{1594323, -32, 1, 0}},
+		{math + "PROGRAM IS a, r BEGIN a := 1000000; r := isqrt(a); WRITE r; a := 99; r := isqrt(a); WRITE r; a := 1; r := isqrt(a); WRITE r; a := -4; r := isqrt(a); WRITE r; END", []int{1000, 9, 1, 0}},
+		{array + "PROGRAM IS t[-2:7], lo, hi BEGIN " + setup + "sort(t, lo, hi); FOR i FROM lo TO hi DO WRITE t[i]; ENDFOR END", []int{-8, -3, 0, 1, 2, 5, 5, 7, 9, 12}},
+		{array + "PROGRAM IS t[-2:7], lo, hi, x, r BEGIN " + setup + "sort(t, lo, hi); x := 7; r := bsearch(t, lo, hi, x); WRITE r; x := 6; r := bsearch(t, lo, hi, x); WRITE r; x := -8; r := bsearch(t, lo, hi, x); WRITE r; END", []int{5, -3, -2}},
+		{array + "PROGRAM IS t[-2:7], lo, hi, r BEGIN " + setup + "r := sum(t, lo, hi); WRITE r; hi := lo - 1; r := sum(t, lo, hi); WRITE r; END", []int{30, 0}},
+		{array + "PROGRAM IS t[-2:7], lo, hi BEGIN " + setup + "reverse(t, lo, hi); WRITE t[-2]; WRITE t[2]; WRITE t[7]; END", []int{2, 12, 5}},
+		{array + "PROGRAM IS t[-2:7], lo, hi, x BEGIN " + setup + "x := 4; lo := 0; hi := 1; fill(t, lo, hi, x); WRITE t[-1]; WRITE t[0]; WRITE t[1]; WRITE t[2]; END", []int{-3, 4, 4, 5}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, "")
+		})
+	}
+}
+
+func TestAsm(t *testing.T) {
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+		userInput      string
+	}{
+		{"PROGRAM IS CONST one = 1; n, c BEGIN n := 100; c := 0; ASM loop: LOAD n; JZERO done; HALF; STORE n; LOAD c; ADD one; STORE c; JUMP loop; done: ENDASM WRITE c; END", []int{7}, ""},
+		{"PROCEDURE halve(x) IS BEGIN ASM LOADI x; HALF; STOREI x; ENDASM END PROGRAM IS y BEGIN y := 50; halve(y); WRITE y; END", []int{25}, ""},
+		{"PROGRAM IS y BEGIN ASM SET -7; PUT 0; GET y; ENDASM WRITE y; END", []int{-7, 42}, "42\n"},
+		{"PROGRAM IS t[1:3], i, p BEGIN i := 2; ASM SET t; ADD i; STORE p; SET 5; STOREI p; ENDASM WRITE t[2]; END", []int{5}, ""},
+		{"PROGRAM IS a, b BEGIN ASM SET 1; JPOS 2; SET 2; STORE a; ENDASM ASM loop: SET 3; STORE b; ENDASM WRITE a; WRITE b; END", []int{1, 3}, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, tt.userInput)
+		})
+	}
+}
+
+func TestContracts(t *testing.T) {
+	const dec = "PROCEDURE dec(x) REQUIRES x > 0 ENSURES x >= 0 IS BEGIN x := x - 1; END\n"
+	const half = "FUNCTION half(n) RETURNS REQUIRES n >= 0 AND n % 2 = 0 ENSURES n >= 0 IS BEGIN IF n = 0 THEN RETURN 0; ENDIF RETURN n / 2; END\n"
+	const bad = "PROCEDURE bad(x) ENSURES x > 0 IS BEGIN IF x > 5 THEN x := 0; ENDIF END\n"
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+		userInput      string
+		flags          []string
+	}{
+		{"PROGRAM IS a BEGIN READ a; ASSERT a != 3; WRITE a; END", []int{4}, "4\n", nil},
+		{"PROGRAM IS a BEGIN READ a;\nASSERT a != 3 AND a < 10; WRITE a; END", []int{tac.AssertFailed, 2}, "3\n", nil},
+		{"PROGRAM IS a BEGIN READ a; ASSERT a != 3; WRITE a; END", []int{3}, "3\n", []string{"-release"}},
+		{dec + half + "PROGRAM IS a, b BEGIN READ a; b := half(a); WRITE b; dec(a); WRITE a; END", []int{4, 7}, "8\n", nil},
+		{dec + half + "PROGRAM IS a, b BEGIN READ a; b := half(a); WRITE b; dec(a); WRITE a; END", []int{0, tac.RequiresFailed, 1}, "0\n", nil},
+		{dec + half + "PROGRAM IS a, b BEGIN READ a; b := half(a); WRITE b; dec(a); WRITE a; END", []int{tac.RequiresFailed, 2}, "7\n", nil},
+		{dec + half + "PROGRAM IS a, b BEGIN READ a; b := half(a); WRITE b; dec(a); WRITE a; END", []int{3, 6}, "7\n", []string{"-release"}},
+		{bad + "PROGRAM IS a BEGIN READ a; bad(a); WRITE a; END", []int{3}, "3\n", nil},
+		{bad + "PROGRAM IS a BEGIN READ a; bad(a); WRITE a; END", []int{tac.EnsuresFailed, 1}, "6\n", nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssemblyFlags(t, tt.flags, tt.inputCode, tt.expectedOutput, tt.userInput)
+		})
+	}
+}
+
+func TestParamModes(t *testing.T) {
+	const procs = "PROCEDURE addto(IN a, IN b, OUT s) IS BEGIN s := a + b; END " +
+		"PROCEDURE incr(INOUT x, IN by) IS BEGIN x := x + by; END "
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+		userInput      string
+	}{
+		{procs + "PROGRAM IS r, v BEGIN READ v; addto(v, 2 * v + 1, r); WRITE r; END", []int{16}, "5\n"},
+		{procs + "PROGRAM IS r BEGIN addto(3, 4, r); incr(r, 10); WRITE r; END", []int{17}, ""},
+		{procs + "PROGRAM IS v BEGIN READ v; incr(v, v); WRITE v; END", []int{10}, "5\n"},
+		{procs + "PROGRAM IS t[1:3], r BEGIN t[2] := 6; addto(t[2], t[2] / 2, r); WRITE r; END", []int{9}, ""},
+		{"PROCEDURE p(IN n, OUT m) IS k BEGIN k := n; k := k + 1; m := k; END PROGRAM IS a BEGIN a := 1; p(a, a); WRITE a; END", []int{2}, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssembly(t, tt.inputCode, tt.expectedOutput, tt.userInput)
+		})
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"assign.imp": "PROCEDURE p(IN a) IS BEGIN\na := 1; END PROGRAM IS BEGIN p(1); END",
+		"value.imp":  "PROCEDURE p(OUT a) IS BEGIN a := 1; END PROGRAM IS x BEGIN\np(x + 1); END",
+		"array.imp":  "PROCEDURE p(IN T a) IS BEGIN\nWRITE a[0]; END PROGRAM IS BEGIN END",
+		"out.imp":    "PROCEDURE p(OUT a) IS b BEGIN\nb := a; a := b; END PROGRAM IS x BEGIN p(x); END",
+		"inout.imp":  "PROCEDURE p(INOUT a) IS BEGIN a := a + 1; END PROGRAM IS x BEGIN\np(x); END",
+		"forward.imp": "PROCEDURE p(IN a) IS BEGIN WRITE a; END PROCEDURE q(OUT b) IS BEGIN b := 1; END " +
+			"PROCEDURE r(IN c) IS BEGIN p(c);\nq(c); END PROGRAM IS BEGIN r(1); END",
+	})
+	tests := []struct {
+		file string
+		code string
+		line int
+	}{
+		{"assign.imp", diag.ErrParamMode, 2},
+		{"value.imp", diag.ErrParamMode, 2},
+		{"array.imp", diag.ErrParamMode, 1},
+		{"out.imp", diag.ErrUninitialized, 2},
+		{"inout.imp", diag.ErrUninitialized, 2},
+		{"forward.imp", diag.ErrParamMode, 2},
+	}
+	for _, tt := range tests {
+		errs := compileDiagnostics(t, filepath.Join(dir, tt.file))
+		if len(errs) == 0 {
+			t.Errorf("%s: expected %s, got no diagnostics", tt.file, tt.code)
+			continue
+		}
+		errs.Sort()
+		if d := errs[0]; d.Code != tt.code || d.Span.Start.Line != tt.line {
+			t.Errorf("%s: expected %s at line %d, got %s", tt.file, tt.code, tt.line, d)
+		}
+	}
+}
+
+func TestScoping(t *testing.T) {
+	testAssembly(t, "PROCEDURE p(x) IS n BEGIN n := 2; x := x * n; END PROGRAM IS n BEGIN n := 5; p(n); WRITE n; END", []int{10}, "")
+	testAssembly(t, "PROCEDURE global(x) IS BEGIN x := x + 1; END PROCEDURE main(y) IS a BEGIN global(y); a := 2; y := y * a; END PROGRAM IS a BEGIN a := 1; main(a); global(a); WRITE a; END", []int{5}, "")
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"read.imp":  "PROCEDURE p(x) IS BEGIN\nx := n; END PROGRAM IS n, m BEGIN n := 1; p(m); END",
+		"write.imp": "PROCEDURE p() IS BEGIN\nREAD n; END PROGRAM IS n BEGIN p(); WRITE n; END",
+		"const.imp": "PROCEDURE p(x) IS BEGIN\nx := k; END PROGRAM IS CONST k = 3; n BEGIN p(n); END",
+	})
+	for _, file := range []string{"read.imp", "write.imp", "const.imp"} {
+		errs := compileDiagnostics(t, filepath.Join(dir, file))
+		errs.Sort()
+		if len(errs) != 1 || errs[0].Code != diag.ErrUndeclared || errs[0].Span.Start.Line != 2 {
+			t.Errorf("%s: expected %s at line 2, got %v", file, diag.ErrUndeclared, errs.Strings())
+		}
+	}
+}
+
+func TestSemanticErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"forward.imp":  "PROCEDURE p(x) IS BEGIN\nq(x); END PROCEDURE q(x) IS BEGIN x := 1; END PROGRAM IS BEGIN END",
+		"twice.imp":    "PROCEDURE p(x) IS BEGIN x := 1; END\nPROCEDURE p(y) IS BEGIN y := 2; END PROGRAM IS BEGIN END",
+		"count.imp":    "PROCEDURE p(x, y) IS BEGIN x := y; END PROGRAM IS a BEGIN\np(a); END",
+		"kind.imp":     "FUNCTION f(x) RETURNS IS BEGIN RETURN x; END PROGRAM IS a BEGIN a := 1;\nf(a); END",
+		"iterator.imp": "PROGRAM IS a BEGIN FOR i FROM 1 TO 3 DO a := i; ENDFOR\nWRITE i; END",
+		"break.imp":    "PROGRAM IS BEGIN\nBREAK; END",
+		"dims.imp":     "PROGRAM IS t[1:2, 1:2] BEGIN\nt[1] := 0; END",
+		"many.imp":     "PROGRAM IS a, a BEGIN\nWRITE b;\nc := 1; END",
+		"overlap.imp":  "PROGRAM IS a BEGIN READ a; CASE a OF 1..5: WRITE 1;\n3: WRITE 2;\n7..6: WRITE 3; ENDCASE END",
+		"asm.imp":      "PROGRAM IS a BEGIN ASM top: LOAD a;\ntop: JUMP top;\nJPOS bottom; ENDASM END",
+	})
+	checkDiagnostics(t, dir, compileDiagnostics, []diagnosticCase{
+		{"forward.imp", []string{diag.ErrUndefinedProc}, []int{2}},
+		{"twice.imp", []string{diag.ErrRedeclared}, []int{2}},
+		{"count.imp", []string{diag.ErrArgumentCount}, []int{2}},
+		{"kind.imp", []string{diag.ErrCallKind}, []int{2}},
+		{"iterator.imp", []string{diag.ErrUndeclared}, []int{2}},
+		{"break.imp", []string{diag.ErrLoopControl}, []int{2}},
+		{"dims.imp", []string{diag.ErrArrayMisuse}, []int{2}},
+		{"many.imp", []string{diag.ErrRedeclared, diag.ErrUndeclared, diag.ErrUndeclared}, []int{1, 2, 3}},
+		{"overlap.imp", []string{diag.ErrCaseLabel, diag.ErrCaseLabel}, []int{2, 3}},
+		{"asm.imp", []string{diag.ErrAsm, diag.ErrAsm}, []int{2, 3}},
+	})
+}
+
+func TestInitialization(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"never.imp":   "PROGRAM IS a, b BEGIN\nb := a; WRITE b; END",
+		"branch.imp":  "PROGRAM IS a, b BEGIN READ a; IF a > 0 THEN b := 1; ENDIF\nWRITE b; END",
+		"both.imp":    "PROGRAM IS a, b BEGIN READ a; IF a > 0 THEN b := 1; ELSE b := 2; ENDIF WRITE b; END",
+		"while.imp":   "PROGRAM IS a, b BEGIN READ a; WHILE a > 0 DO b := a; a := a - 1; ENDWHILE\nWRITE b; END",
+		"repeat.imp":  "PROGRAM IS a, b BEGIN READ a; REPEAT b := a; a := a - 1; UNTIL a = 0; WRITE b; END",
+		"for.imp":     "PROGRAM IS a, b BEGIN READ a; FOR i FROM 1 TO a DO b := i; ENDFOR\nWRITE b; END",
+		"break.imp":   "PROGRAM IS a, b BEGIN READ a; REPEAT IF a = 0 THEN BREAK; ENDIF b := a; UNTIL 1 = 1;\nWRITE b; END",
+		"case.imp":    "PROGRAM IS a, b BEGIN READ a; CASE a OF 1: b := 1; 2: b := 2; ELSE b := 0; ENDCASE WRITE b; END",
+		"noelse.imp":  "PROGRAM IS a, b BEGIN READ a; CASE a OF 1: b := 1; ENDCASE\nWRITE b; END",
+		"out.imp":     "PROCEDURE p(OUT x) IS BEGIN x := 1; END PROGRAM IS a BEGIN p(a); WRITE a; END",
+		"ref.imp":     "PROCEDURE p(x) IS BEGIN x := 1; END PROGRAM IS a BEGIN p(a); WRITE a; END",
+		"in.imp":      "PROCEDURE p(IN x) IS BEGIN WRITE x; END PROGRAM IS a BEGIN\np(a); END",
+		"outread.imp": "PROCEDURE p(OUT x) IS BEGIN\nWRITE x; x := 1; END PROGRAM IS a BEGIN p(a); END",
+		"forever.imp": "PROGRAM IS a, b BEGIN READ a; REPEAT WRITE a; UNTIL 1 = 0;\nWRITE b; END",
+		"whileon.imp": "PROGRAM IS a, b BEGIN READ a; WHILE 1 = 1 DO WRITE a; ENDWHILE\nWRITE b; END",
+		"escape.imp":  "PROGRAM IS a, b BEGIN READ a; REPEAT IF a = 0 THEN BREAK; ENDIF UNTIL 1 = 0;\nWRITE b; END",
+		"return.imp":  "FUNCTION f(n) RETURNS IS r BEGIN IF n > 0 THEN RETURN n; ENDIF r := 0; RETURN r; END PROGRAM IS a BEGIN a := f(1); WRITE a; END",
+	})
+	checkDiagnostics(t, dir, compileDiagnostics, []diagnosticCase{
+		{"never.imp", []string{diag.ErrUninitialized}, []int{2}},
+		{"branch.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+		{"both.imp", nil, nil},
+		{"while.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+		{"repeat.imp", nil, nil},
+		{"for.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+		{"break.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+		{"case.imp", nil, nil},
+		{"noelse.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+		{"out.imp", nil, nil},
+		{"ref.imp", nil, nil},
+		{"in.imp", []string{diag.ErrUninitialized}, []int{2}},
+		{"outread.imp", []string{diag.ErrUninitialized}, []int{2}},
+		{"forever.imp", nil, nil},
+		{"whileon.imp", nil, nil},
+		{"escape.imp", []string{diag.ErrUninitialized}, []int{2}},
+		{"return.imp", nil, nil},
+	})
+}
+
+func TestIndexRange(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"const.imp":    "PROGRAM IS t[0:9] BEGIN\nt[15] := 1; WRITE t[9]; END",
+		"read.imp":     "PROGRAM IS x[-5:5] BEGIN READ x[-5];\nREAD x[6]; END",
+		"named.imp":    "PROGRAM IS CONST n = 4; t[1:n] BEGIN t[n] := 1;\nWRITE t[n + 1]; END",
+		"reversed.imp": "PROGRAM IS a,\nt[10:2] BEGIN a := 1; WRITE a; END",
+		"for.imp":      "PROGRAM IS t[0:9] BEGIN FOR i FROM 0 TO 10 DO\nt[i] := i; ENDFOR END",
+		"downto.imp":   "PROGRAM IS t[0:9] BEGIN FOR i FROM 9 DOWNTO 0 DO t[i] := i;\nWRITE t[i - 1]; ENDFOR END",
+		"nested.imp":   "PROGRAM IS m[1:3, 1:3] BEGIN FOR i FROM 1 TO 3 DO FOR j FROM i TO 3 DO m[i, j] := 0;\nm[j, i + 1] := 0; ENDFOR ENDFOR END",
+		"unknown.imp":  "PROGRAM IS t[0:9], n BEGIN READ n; FOR i FROM n TO 20 DO t[n] := i; ENDFOR END",
+		"proc.imp":     "PROCEDURE p(T a) IS BEGIN a[100] := 1; END PROGRAM IS t[0:9] BEGIN p(t); END",
+		"param.imp":    "PROCEDURE p(T m[0:1, 0:2]) IS BEGIN\nm[0, 3] := 1; END PROGRAM IS t[4:5, 0:2] BEGIN p(t); END",
+		"short.imp":    "PROCEDURE p(T m[0:2, 0:2]) IS BEGIN m[0, 0] := 1; END PROGRAM IS t[0:1, 0:2] BEGIN\np(t); END",
+		"offset.imp":   "PROGRAM IS t[0:10] BEGIN FOR i FROM 0 TO 10 DO\nt[i + 1] := i; ENDFOR END",
+		"same.imp":     "PROGRAM IS t[0:10] BEGIN FOR i FROM 0 TO 10 DO\nt[i - i] := i; ENDFOR WRITE t[0]; END",
+		"sum.imp":      "PROGRAM IS t[0:9] BEGIN FOR i FROM 0 TO 5 DO FOR j FROM 0 TO 5 DO\nt[i + j] := 0; ENDFOR ENDFOR END",
+		"inner.imp":    "PROGRAM IS t[0:9] BEGIN FOR i FROM 0 TO 9 DO FOR j FROM i TO 9 DO\nt[j + 1] := 0; ENDFOR ENDFOR END",
+	})
+	checkDiagnostics(t, dir, compileDiagnostics, []diagnosticCase{
+		{"const.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"read.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"named.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"reversed.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"for.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"downto.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"nested.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"unknown.imp", nil, nil},
+		{"proc.imp", nil, nil},
+		{"param.imp", []string{diag.ErrIndexRange}, []int{2}},
+		{"short.imp", []string{diag.ErrArgumentKind}, []int{2}},
+		{"offset.imp", []string{diag.ErrIndexRange}, []int{2}},
+		// Ranges of several iterators, or of an iterator whose loop bounds
+		// are not constants, are only estimates.
+		{"same.imp", []string{diag.WarnIndexRange}, []int{2}},
+		{"sum.imp", []string{diag.WarnIndexRange}, []int{2}},
+		{"inner.imp", []string{diag.WarnIndexRange}, []int{2}},
+	})
+	testAssembly(t, "PROGRAM IS t[0:10] BEGIN FOR i FROM 0 TO 10 DO t[i - i] := i; ENDFOR WRITE t[0]; END", []int{10}, "")
+}
+
+func TestWarnings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"unused.imp":  "PROGRAM IS a,\nb,\nc BEGIN READ a; c := a; WRITE a; END",
+		"iter.imp":    "PROGRAM IS\ni BEGIN FOR i FROM 1 TO 2 DO WRITE i; ENDFOR END",
+		"proc.imp":    "PROCEDURE p() IS BEGIN WRITE 1; END\nPROCEDURE q() IS BEGIN WRITE 2; END PROCEDURE r() IS BEGIN q(); END PROGRAM IS BEGIN r(); END",
+		"lib.imp":     "INCLUDE \"std/math.imp\"; PROGRAM IS BEGIN WRITE 1; END",
+		"repeat.imp":  "PROGRAM IS a BEGIN READ a; REPEAT WRITE a; UNTIL 1 = 0;\nWRITE a; END",
+		"break.imp":   "PROGRAM IS a BEGIN READ a; REPEAT IF a = 0 THEN BREAK; ENDIF a := a - 1; UNTIL 1 = 0; WRITE a; END",
+		"return.imp":  "FUNCTION f(n) RETURNS IS BEGIN IF n > 0 THEN RETURN 1; ELSE RETURN 0; ENDIF\nWRITE n; END PROGRAM IS a BEGIN a := f(1); WRITE a; END",
+		"self.imp":    "PROGRAM IS a, t[1:2] BEGIN READ a; t[1] := a;\na := a;\nt[1] := t[1]; t[1] := t[2]; WRITE t[1]; END",
+		"maybe.imp":   "PROGRAM IS a, b BEGIN READ a; IF a > 0 THEN b := 1; ENDIF\nWRITE b; END",
+		"nothing.imp": "PROGRAM IS a BEGIN READ a; WRITE a; END",
+	})
+	// warnings checks a file with sema and lint, at the levels specs set.
+	warnings := func(specs ...string) func(t *testing.T, path string) diag.List {
+		return func(t *testing.T, path string) diag.List {
+			t.Helper()
+			var config lint.Config
+			for _, spec := range specs {
+				if err := config.Set(spec); err != nil {
+					t.Fatalf("%s: %v", spec, err)
+				}
+			}
+			program, err := include.NewLoader().Load(path)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", path, err)
+			}
+			diags := config.Apply(sema.Check(program))
+			return append(diags, lint.Run(program, &config)...)
+		}
+	}
+	checkDiagnostics(t, dir, warnings(), []diagnosticCase{
+		{"unused.imp", []string{diag.WarnUnusedVariable, diag.WarnUnusedVariable}, []int{2, 3}},
+		{"iter.imp", []string{diag.WarnUnusedVariable}, []int{2}},
+		{"proc.imp", []string{diag.WarnUnusedProcedure}, []int{1}},
+		{"lib.imp", nil, nil},
+		{"repeat.imp", []string{diag.WarnUnreachable}, []int{2}},
+		{"break.imp", nil, nil},
+		{"return.imp", []string{diag.WarnUnreachable}, []int{2}},
+		{"self.imp", []string{diag.WarnSelfAssign, diag.WarnSelfAssign}, []int{2, 3}},
+		{"maybe.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+		{"nothing.imp", nil, nil},
+	})
+	checkDiagnostics(t, dir, warnings("self-assign=off"), []diagnosticCase{
+		{"self.imp", nil, nil},
+	})
+	checkDiagnostics(t, dir, warnings("all=off"), []diagnosticCase{
+		{"maybe.imp", nil, nil},
+	})
+	promote := warnings("self-assign=error", "uninitialized=error")
+	checkDiagnostics(t, dir, promote, []diagnosticCase{
+		{"self.imp", []string{diag.WarnSelfAssign, diag.WarnSelfAssign}, []int{2, 3}},
+		{"maybe.imp", []string{diag.WarnMaybeUninitialized}, []int{2}},
+	})
+	for _, file := range []string{"self.imp", "maybe.imp"} {
+		if diags := promote(t, filepath.Join(dir, file)); !diags.HasErrors() {
+			t.Errorf("%s: expected warnings made errors, got %v", file, diags.Strings())
+		}
+	}
+
+	var config lint.Config
+	if err := config.Set("unused=off"); err == nil {
+		t.Errorf("expected an error for an unknown warning")
+	}
+	if err := config.Set("unreachable=loud"); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
+
+func TestUnusedProceduresDropped(t *testing.T) {
+	p := parser.New(lexer.New("PROCEDURE used() IS BEGIN WRITE 1; END PROCEDURE unused() IS BEGIN WRITE 2; END PROGRAM IS BEGIN used(); END"))
+	program := p.ParseProgram()
+	g := tac.NewGenerator()
+	g.Generate(program)
+	var labels []string
+	for _, ins := range g.Instructions {
+		labels = append(labels, ins.Labels...)
+	}
+	if !slices.Contains(labels, "used") || slices.Contains(labels, "unused") {
+		t.Errorf("expected code for used only, got labels %v", labels)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	const src = "PROCEDURE a(x) IS BEGIN b(x); END\n" +
+		"PROCEDURE b(x) IS BEGIN a(x); END\n" +
+		"PROCEDURE RECURSIVE c(x) IS BEGIN IF x > 0 THEN x := x - 1; c(x); ENDIF END\n" +
+		"FUNCTION f(x) RETURNS IS BEGIN RETURN x; END\n" +
+		"PROCEDURE unused() IS BEGIN WRITE 1; END\n" +
+		"PROGRAM IS n BEGIN n := f(3); c(n); END"
+	p := parser.New(lexer.New(src))
+	g := callgraph.Build(p.ParseProgram())
+
+	var forward []string
+	for _, call := range g.ForwardCalls() {
+		forward = append(forward, call.Caller.Name+"->"+call.Callee.Name)
+	}
+	if !slices.Equal(forward, []string{"a->b"}) {
+		t.Errorf("expected forward call a->b, got %v", forward)
+	}
+	var cycles []string
+	for _, cycle := range g.Cycles() {
+		var names []string
+		for _, n := range cycle {
+			names = append(names, n.Name)
+		}
+		cycles = append(cycles, strings.Join(names, ","))
+	}
+	if !slices.Equal(cycles, []string{"a,b", "c"}) {
+		t.Errorf("expected cycles [a,b c], got %v", cycles)
+	}
+	var unreachable []string
+	for _, n := range g.Unreachable() {
+		unreachable = append(unreachable, n.Name)
+	}
+	if !slices.Equal(unreachable, []string{"a", "b", "unused"}) {
+		t.Errorf("expected a, b and unused unreachable, got %v", unreachable)
+	}
+	if !g.Recursive("a", "b") || g.Recursive(callgraph.Main, "c") {
+		t.Errorf("expected only calls within a cycle to be recursive")
+	}
+
+	var dot strings.Builder
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"a" -> "b" [color=red];`, `"PROGRAM" -> "f";`, `"c" [peripheries=2];`, `"unused" [style=dashed, color=gray];`} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("expected %s in DOT output:\n%s", want, dot.String())
+		}
+	}
+
+	var decoded struct {
+		Nodes []struct {
+			Name      string
+			Reachable bool
+		}
+		Calls []struct {
+			Caller, Callee string
+			Line           int
+			Forward        bool
+		}
+	}
+	var out strings.Builder
+	if err := g.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(out.String()), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(decoded.Nodes) != 6 || len(decoded.Calls) != 5 {
+		t.Fatalf("expected 6 nodes and 5 calls, got %+v", decoded)
+	}
+	if call := decoded.Calls[0]; call.Caller != "a" || call.Callee != "b" || call.Line != 1 || !call.Forward {
+		t.Errorf("expected the forward call a->b on line 1 first, got %+v", call)
+	}
+}
+
+func TestBoundsCheck(t *testing.T) {
+	const prog = "PROGRAM IS t[3:5], n BEGIN READ n;\nt[n] := 7; WRITE t[n]; END"
+	const proc = "PROCEDURE p(k) IS b[1:2] BEGIN\nb[k] := k; WRITE b[k]; END\nPROGRAM IS n BEGIN READ n; p(n); END"
+	const param = "PROCEDURE p(T a, i) IS BEGIN\na[i] := 1; END PROGRAM IS t[0:1], i BEGIN READ i; p(t, i); WRITE 5; END"
+	const nested = "PROCEDURE q(T b, i) IS x BEGIN\nx := b[i]; WRITE x; END PROCEDURE p(T a, i) IS BEGIN q(a, i); END PROGRAM IS t[3:4], i BEGIN t[3] := 8; t[4] := 9; READ i; p(t, i); END"
+	bounds := []string{"-bounds"}
+	cases := []struct {
+		inputCode      string
+		expectedOutput []int
+		userInput      string
+		flags          []string
+	}{
+		{prog, []int{7}, "5\n", bounds},
+		{prog, []int{translator.BoundsFailed, 2, 6, 't'}, "6\n", bounds},
+		{prog, []int{translator.BoundsFailed, 2, 2, 't'}, "2\n", bounds},
+		{prog, []int{7}, "6\n", nil},
+		{proc, []int{2}, "2\n", bounds},
+		{proc, []int{translator.BoundsFailed, 2, 0, 'b'}, "0\n", bounds},
+		{"PROGRAM IS t[0:1, 2:3], i, j BEGIN READ i; READ j; t[i, j] := 1; WRITE t[i, j]; END", []int{1}, "1\n3\n", bounds},
+		{"PROGRAM IS t[0:1, 2:3], i, j BEGIN READ i; READ j; t[i, j] := 1; WRITE t[i, j]; END", []int{translator.BoundsFailed, 1, 4, 't'}, "1\n4\n", bounds},
+		{"PROGRAM IS t[0:1], i BEGIN FOR i FROM 0 TO 1 DO t[i] := i; ENDFOR WRITE t[1]; END", []int{1}, "", bounds},
+		// The flattened index 4 is in range, the second index is not.
+		{"PROGRAM IS t[0:1, 0:2], i, j BEGIN READ i; READ j;\nt[i, j] := 1; WRITE t[i, j]; END", []int{translator.BoundsFailed, 2, 4, 't'}, "0\n4\n", bounds},
+		{"PROCEDURE p(T m[0:1, 0:2], i, j) IS BEGIN\nm[i, j] := 1; END PROGRAM IS t[5:6, 0:2], i, j BEGIN READ i; READ j; p(t, i, j); WRITE t[6, 2]; END", []int{1}, "1\n2\n", bounds},
+		{"PROCEDURE p(T m[0:1, 0:2], i, j) IS BEGIN\nm[i, j] := 1; END PROGRAM IS t[5:6, 0:2], i, j BEGIN READ i; READ j; p(t, i, j); WRITE t[6, 2]; END", []int{translator.BoundsFailed, 2, 5, 'm'}, "5\n0\n", bounds},
+		// A T parameter of one dimension is checked against the bounds of
+		// the array passed for it, however many calls it is passed down.
+		{param, []int{5}, "1\n", bounds},
+		{param, []int{translator.BoundsFailed, 2, 2, 'a'}, "2\n", bounds},
+		{param, []int{5}, "2\n", nil},
+		{nested, []int{8}, "3\n", bounds},
+		{nested, []int{translator.BoundsFailed, 2, 5, 'b'}, "5\n", bounds},
+		{nested, []int{translator.BoundsFailed, 2, 2, 'b'}, "2\n", bounds},
+		{"PROCEDURE RECURSIVE f(T a, n) IS loc[3:3], m BEGIN IF n > 0 THEN loc[3] := n; m := n - 1; f(loc, m); a[3] := a[3] + loc[3]; ENDIF END PROGRAM IS t[3:3], k BEGIN t[3] := 0; k := 4; f(t, k); WRITE t[3]; END", []int{10}, "", bounds},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.inputCode, func(t *testing.T) {
+			testAssemblyFlags(t, tt.flags, tt.inputCode, tt.expectedOutput, tt.userInput)
+		})
+	}
+}
+
 func TestWrite(t *testing.T) {
 	cases := []struct {
 		input_code     string
@@ -520,3 +1409,45 @@ END
 	}
 	testAssembly(t, inputCode, expected, "")
 }
+
+func TestSyntaxErrorRecovery(t *testing.T) {
+	inputCode := `PROGRAM IS
+ a, b
+BEGIN
+ a := ;
+ IF a > THEN
+   b := 1 +;
+ ENDIF
+ WHILE a < b DO
+   READ a
+ ENDWHILE
+ WRITE a;
+END
+`
+	p := parser.New(lexer.NewFile("recovery.imp", inputCode))
+	program := p.ParseProgram()
+	var lines []int
+	for _, d := range p.Errors() {
+		lines = append(lines, d.Span.Start.Line)
+	}
+	expected := []int{4, 5, 6, 10}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected errors on lines %v, got %v", expected, p.Errors().Strings())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Fatalf("expected errors on lines %v, got %v", expected, p.Errors().Strings())
+		}
+	}
+	if program.Main == nil || len(program.Main.Commands) != 4 {
+		t.Fatalf("expected a partial main with 4 commands, got %v", program.Main)
+	}
+
+	// A mistake is reported once, not again by every construct that fails
+	// on the same token.
+	p = parser.New(lexer.NewFile("for.imp", "PROGRAM IS hi, t[0:9] BEGIN\nFOR i FROM 0 TO hi) DO t[i] := i; ENDFOR\nWRITE hi; END"))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 1 || errs[0].Span.Start.Line != 2 {
+		t.Errorf("expected one error on line 2, got %v", errs.Strings())
+	}
+}
@@ -0,0 +1,39 @@
+package diag
+
+// Diagnostic codes. E0xx are errors reading and parsing the source, E1xx
+// semantic errors found while resolving names and E2xx failures of code
+// generation. W1xx are warnings about code that compiles but is likely
+// wrong; a warning that is the weaker form of an error shares its number, the
+// others are numbered from W120.
+const (
+	ErrSyntax  = "E001" // unexpected token or malformed construct
+	ErrInclude = "E002" // INCLUDE of a file that cannot be read, or an include cycle
+
+	ErrUndeclared       = "E101" // use of a name that is not declared
+	ErrRedeclared       = "E102" // name declared twice in one scope
+	ErrReserved         = "E103" // name reserved for the prelude
+	ErrUndefinedProc    = "E104" // call of an unknown procedure
+	ErrIteratorModified = "E105" // assignment to a FOR iterator
+	ErrArrayMisuse      = "E106" // array used without index or scalar with one
+	ErrUninitialized    = "E107" // read of a variable that was never assigned
+	ErrArgumentKind     = "E108" // array passed for a scalar parameter or vice versa
+	ErrArgumentCount    = "E109" // wrong number of arguments in a call
+	ErrRecursiveCall    = "E110" // procedure calls itself
+	ErrCallKind         = "E111" // function called as a statement or procedure used as a value
+	ErrReturn           = "E112" // RETURN outside a function, or a function without one
+	ErrLoopControl      = "E113" // BREAK or CONTINUE outside a loop
+	ErrCaseLabel        = "E114" // CASE labels that overlap or an empty label range
+	ErrConstant         = "E115" // constant assigned to, or not computable at compile time
+	ErrAsm              = "E116" // undefined or duplicate label in an ASM block
+	ErrParamMode        = "E117" // IN parameter assigned, or a value passed for an OUT or INOUT one
+	ErrIndexRange       = "E118" // constant index outside the bounds of an array, or bounds in reverse
+
+	ErrCodegen = "E200" // internal failure while generating code
+
+	WarnMaybeUninitialized = "W107" // read of a variable not assigned on every path to it
+	WarnIndexRange         = "W118" // index whose estimated range leaves the bounds of an array
+	WarnUnusedVariable     = "W120" // variable that is never read
+	WarnUnusedProcedure    = "W121" // procedure that main never calls
+	WarnUnreachable        = "W122" // command that control can never reach
+	WarnSelfAssign         = "W123" // variable assigned to itself
+)
@@ -0,0 +1,182 @@
+// Package diag defines the diagnostics reported by every compiler phase, so
+// they can be filtered, sorted and rendered the same way.
+package diag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Meduza3/imp/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// Span is a source range. End points just past the last character and may be
+// unknown, in which case the span covers a single position.
+type Span struct {
+	Start token.Position `json:"start"`
+	End   token.Position `json:"end"`
+}
+
+// SpanOf returns the span covered by an AST node or anything else that knows
+// its extent.
+func SpanOf(n interface {
+	Pos() token.Position
+	End() token.Position
+}) Span {
+	return Span{Start: n.Pos(), End: n.End()}
+}
+
+// TokenSpan returns the span covered by a single token.
+func TokenSpan(tok token.Token) Span {
+	return Span{Start: tok.Pos, End: tok.End()}
+}
+
+// At returns an empty span at pos.
+func At(pos token.Position) Span {
+	return Span{Start: pos, End: pos}
+}
+
+func (s Span) String() string { return s.Start.String() }
+
+// Fix is a suggested edit replacing the text covered by Span.
+type Fix struct {
+	Message     string `json:"message"`
+	Span        Span   `json:"span"`
+	Replacement string `json:"replacement"`
+}
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Span     Span     `json:"span"`
+	Message  string   `json:"message"`
+	Notes    []string `json:"notes,omitempty"`
+	Fix      *Fix     `json:"fix,omitempty"`
+}
+
+// Errorf returns an error diagnostic. It is also an error value, so phases can
+// return it through their usual error paths.
+func Errorf(code string, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warnf returns a warning diagnostic.
+func Warnf(code string, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Warning, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// WithNote appends a note and returns d for chaining.
+func (d *Diagnostic) WithNote(format string, args ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, fmt.Sprintf(format, args...))
+	return d
+}
+
+// WithFix attaches a suggested fix and returns d for chaining.
+func (d *Diagnostic) WithFix(message string, span Span, replacement string) *Diagnostic {
+	d.Fix = &Fix{Message: message, Span: span, Replacement: replacement}
+	return d
+}
+
+func (d *Diagnostic) Error() string { return d.String() }
+
+// String renders the diagnostic on one line as "file:line:col: error[E101]: message".
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	if d.Span.Start.IsValid() || d.Span.Start.File != "" {
+		sb.WriteString(d.Span.Start.String())
+		sb.WriteString(": ")
+	}
+	sb.WriteString(d.Severity.String())
+	if d.Code != "" {
+		sb.WriteString("[" + d.Code + "]")
+	}
+	sb.WriteString(": ")
+	sb.WriteString(d.Message)
+	return sb.String()
+}
+
+// List collects the diagnostics of one or more phases.
+type List []Diagnostic
+
+// Add appends d. A nil diagnostic is ignored.
+func (l *List) Add(d *Diagnostic) {
+	if d != nil {
+		*l = append(*l, *d)
+	}
+}
+
+// Errorf appends an error diagnostic.
+func (l *List) Errorf(code string, span Span, format string, args ...interface{}) {
+	l.Add(Errorf(code, span, format, args...))
+}
+
+// Warnf appends a warning diagnostic.
+func (l *List) Warnf(code string, span Span, format string, args ...interface{}) {
+	l.Add(Warnf(code, span, format, args...))
+}
+
+// HasErrors reports whether the list holds at least one error.
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the diagnostics for which keep returns true.
+func (l List) Filter(keep func(Diagnostic) bool) List {
+	var out List
+	for _, d := range l {
+		if keep(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Sort orders the list by file, then position, then severity.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Span.Start, l[j].Span.Start
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		return l[i].Severity < l[j].Severity
+	})
+}
+
+// Strings renders every diagnostic on one line.
+func (l List) Strings() []string {
+	out := make([]string, 0, len(l))
+	for _, d := range l {
+		out = append(out, d.String())
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sources maps file names to their contents so diagnostics can quote the
+// offending line. Files missing from the map are rendered without a snippet.
+type Sources map[string]string
+
+// Fprint writes every diagnostic in l to w, followed by the source line it
+// points at, a caret marker under the span, its notes and its suggested fix.
+func Fprint(w io.Writer, l List, sources Sources) {
+	for _, d := range l {
+		fmt.Fprintln(w, d.String())
+		if line, ok := sources.line(d.Span.Start.File, d.Span.Start.Line); ok {
+			fmt.Fprintf(w, "    %s\n", line)
+			fmt.Fprintf(w, "    %s\n", marker(line, d.Span))
+		}
+		for _, note := range d.Notes {
+			fmt.Fprintf(w, "    note: %s\n", note)
+		}
+		if d.Fix != nil {
+			fmt.Fprintf(w, "    help: %s: %q\n", d.Fix.Message, d.Fix.Replacement)
+		}
+	}
+}
+
+func (s Sources) line(file string, n int) (string, bool) {
+	src, ok := s[file]
+	if !ok || n <= 0 {
+		return "", false
+	}
+	lines := strings.Split(src, "\n")
+	if n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// marker underlines the part of line covered by span. Tabs are kept so the
+// carets line up with the quoted source.
+func marker(line string, span Span) string {
+	start := span.Start.Column - 1
+	if start < 0 || start > len(line) {
+		start = len(line)
+	}
+	width := 1
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		width = span.End.Column - span.Start.Column
+	}
+	var sb strings.Builder
+	for _, ch := range line[:start] {
+		if ch == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	sb.WriteString(strings.Repeat("^", width))
+	return sb.String()
+}
@@ -0,0 +1,169 @@
+// Package include loads a program together with the libraries it pulls in
+// with INCLUDE "file"; and merges them into a single ast.Program.
+//
+// A path starting with std/ names a file of the standard library embedded
+// in the compiler. Any other path is resolved relative to the directory of
+// the including file first, then against each search path in order. Every
+// file is merged once, however many files include it, and an include cycle
+// is an error. Tokens keep the name of the file they were read from, so
+// diagnostics point into it.
+package include
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/lexer"
+	"github.com/Meduza3/imp/parser"
+	"github.com/Meduza3/imp/stdlib"
+)
+
+// Loader reads a program and the files it includes. A Loader is used for a
+// single program.
+type Loader struct {
+	SearchPaths []string
+	// Sources holds every file read, for rendering diagnostics.
+	Sources diag.Sources
+
+	errors diag.List
+	loaded map[string]bool // absolute paths of the files read so far
+	chain  []string        // files being included, outermost first
+}
+
+func NewLoader(searchPaths ...string) *Loader {
+	return &Loader{
+		SearchPaths: searchPaths,
+		Sources:     diag.Sources{},
+		loaded:      map[string]bool{},
+	}
+}
+
+// Errors returns the syntax errors of every file read and the errors of
+// INCLUDE directives that could not be resolved.
+func (l *Loader) Errors() diag.List {
+	return l.errors
+}
+
+// Load parses the program in file and everything it includes. Included
+// procedures come before those of the including file, in INCLUDE order, so
+// they are declared before use. The error is only for a file that cannot be
+// read; other problems are collected in Errors.
+func (l *Loader) Load(file string) (*ast.Program, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	l.Sources[file] = string(content)
+	p := parser.New(lexer.NewFile(file, string(content)))
+	program := p.ParseProgram()
+	l.errors = append(l.errors, p.Errors()...)
+
+	key := absolute(file)
+	l.loaded[key] = true
+	l.chain = append(l.chain, key)
+	program.Procedures = append(l.includeAll(program), program.Procedures...)
+	program.Includes = nil
+	l.chain = l.chain[:len(l.chain)-1]
+	return program, nil
+}
+
+// includeAll returns the procedures of the files program includes.
+func (l *Loader) includeAll(program *ast.Program) []*ast.Procedure {
+	var procedures []*ast.Procedure
+	for _, include := range program.Includes {
+		procedures = append(procedures, l.include(program.File, include)...)
+	}
+	return procedures
+}
+
+func (l *Loader) include(from string, include *ast.Include) []*ast.Procedure {
+	span := diag.TokenSpan(include.PathTok)
+	file, key, ok := l.resolve(from, include.Path)
+	if !ok {
+		d := diag.Errorf(diag.ErrInclude, span, "cannot find included file %q", include.Path)
+		if strings.HasPrefix(include.Path, stdlib.Prefix) {
+			d.WithNote("the standard library has %s", strings.Join(stdlib.Names(), ", "))
+		} else {
+			d.WithNote("searched the directory of %s and %d search paths", from, len(l.SearchPaths))
+		}
+		l.errors.Add(d)
+		return nil
+	}
+	for i, active := range l.chain {
+		if active == key {
+			cycle := append(append([]string{}, l.chain[i:]...), key)
+			for j := range cycle {
+				cycle[j] = filepath.Base(cycle[j])
+			}
+			l.errors.Errorf(diag.ErrInclude, span, "include cycle: %s", strings.Join(cycle, " -> "))
+			return nil
+		}
+	}
+	if l.loaded[key] {
+		return nil
+	}
+	l.loaded[key] = true
+
+	content, err := read(file)
+	if err != nil {
+		l.errors.Errorf(diag.ErrInclude, span, "cannot read included file: %v", err)
+		return nil
+	}
+	l.Sources[file] = content
+	p := parser.New(lexer.NewFile(file, content))
+	fragment := p.ParseFragment()
+	l.errors = append(l.errors, p.Errors()...)
+
+	l.chain = append(l.chain, key)
+	procedures := append(l.includeAll(fragment), fragment.Procedures...)
+	l.chain = l.chain[:len(l.chain)-1]
+	return procedures
+}
+
+// resolve finds the file an INCLUDE in from names, and the key that
+// identifies the file however it is named.
+func (l *Loader) resolve(from, path string) (file, key string, ok bool) {
+	if strings.HasPrefix(path, stdlib.Prefix) {
+		file, ok := stdlib.Lookup(path)
+		return file, file, ok
+	}
+	if filepath.IsAbs(path) {
+		return path, absolute(path), isFile(path)
+	}
+	dirs := append([]string{filepath.Dir(from)}, l.SearchPaths...)
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, path)
+		if isFile(candidate) {
+			return candidate, absolute(candidate), true
+		}
+	}
+	return "", "", false
+}
+
+// read returns the contents of a file returned by resolve.
+func read(file string) (string, error) {
+	if source, ok := stdlib.Source(file); ok {
+		return source, nil
+	}
+	content, err := os.ReadFile(file)
+	return string(content), err
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// absolute identifies a file independently of the path it was reached by.
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	return path
+}
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"github.com/Meduza3/imp/code"
 	"github.com/Meduza3/imp/token"
 )
 
@@ -10,11 +11,14 @@ type Lexer struct {
 	readPosition int // position + 1
 	ch           byte
 	currentLine  int
+	lineStart    int    // offset of the first character of the current line
+	file         string // name of the source the input came from
 }
 
 func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
 	var tok token.Token
+	pos := l.pos()
 	// defer func() {
 	// 	fmt.Println(tok)
 	// }()
@@ -23,9 +27,11 @@ func (l *Lexer) NextToken() token.Token {
 		tok = l.newToken(token.PLUS, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
-			tok = token.Token{Type: token.NEQUALS, Literal: "!=", Line: l.currentLine}
+			l.readChar()
+			tok = token.Token{Type: token.NEQUALS, Literal: "!="}
+		} else {
+			tok = l.newToken(token.ILLEGAL, l.ch)
 		}
-		l.readChar()
 	case '(':
 		tok = l.newToken(token.LPAREN, l.ch)
 	case ')':
@@ -40,12 +46,19 @@ func (l *Lexer) NextToken() token.Token {
 		peeked := l.peekChar()
 		if peeked == '=' {
 			l.readChar()
-			tok = token.Token{Type: token.ASSIGN, Literal: ":=", Line: l.currentLine}
+			tok = token.Token{Type: token.ASSIGN, Literal: ":="}
 		} else {
 			tok = l.newToken(token.COLON, ':')
 		}
 	case '-':
 		tok = l.newToken(token.MINUS, l.ch)
+	case '.':
+		if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.RANGE, Literal: ".."}
+		} else {
+			tok = l.newToken(token.ILLEGAL, l.ch)
+		}
 
 	case '*':
 		tok = l.newToken(token.MULT, l.ch)
@@ -54,7 +67,7 @@ func (l *Lexer) NextToken() token.Token {
 	case '%':
 		tok = l.newToken(token.MODULO, l.ch)
 	case '=':
-		tok = token.Token{Type: token.EQUALS, Literal: "="}
+		tok = l.newToken(token.EQUALS, l.ch)
 	case '<':
 		peeked := l.peekChar()
 		if peeked == '=' {
@@ -67,12 +80,18 @@ func (l *Lexer) NextToken() token.Token {
 		peeked := l.peekChar()
 		if peeked == '=' {
 			l.readChar()
-			tok = token.Token{Type: token.GEQ, Literal: ">=", Line: l.currentLine}
+			tok = token.Token{Type: token.GEQ, Literal: ">="}
 		} else {
 			tok = l.newToken(token.GR, l.ch)
 		}
 	case ';':
 		tok = l.newToken(token.SEMICOLON, l.ch)
+	case '"':
+		literal, ok := l.readString()
+		if !ok {
+			return token.Token{Type: token.ILLEGAL, Literal: literal, Pos: pos}
+		}
+		return token.Token{Type: token.STRING, Literal: literal, Pos: pos}
 	case '#':
 		l.skipComment()
 		return l.NextToken()
@@ -83,141 +102,59 @@ func (l *Lexer) NextToken() token.Token {
 	default:
 		if isDigit(l.ch) {
 			literal := l.readNumber()
-			tok = token.Token{Type: token.NUM, Literal: literal, Line: l.currentLine}
-			return tok
+			return token.Token{Type: token.NUM, Literal: literal, Pos: pos}
 		} else if isLowercaseLetter(l.ch) {
 			literal := l.readPidentifier()
-			tok = token.Token{Type: token.PIDENTIFIER, Literal: literal, Line: l.currentLine}
-			return tok
+			return token.Token{Type: token.PIDENTIFIER, Literal: literal, Pos: pos}
 		} else if isUppercaseLetter(l.ch) {
 			literal := l.readKeyword()
 			tokenType, ok := token.LookupKeyword(literal)
 			if !ok {
-				tok = token.Token{Type: token.ILLEGAL, Literal: literal, Line: l.currentLine}
-				return tok
+				if _, ok := code.Operands(literal); ok {
+					return token.Token{Type: token.OPCODE, Literal: literal, Pos: pos}
+				}
+				return token.Token{Type: token.ILLEGAL, Literal: literal, Pos: pos}
 			}
-			tok = token.Token{Type: tokenType, Literal: literal, Line: l.currentLine}
-			return tok
+			return token.Token{Type: tokenType, Literal: literal, Pos: pos}
 		} else {
 			tok = l.newToken(token.ILLEGAL, l.ch)
 		}
 	}
+	tok.Pos = pos
 	l.readChar()
 	return tok
 }
 
 func (l *Lexer) newToken(tokenType token.TokenType, ch byte) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch), Line: l.currentLine}
+	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
-func New(input string) *Lexer {
-	input = `
-	PROCEDURE built_in_mult() IS temp, left_sign BEGIN IF built_in_left <= 0 THEN built_in_right:=0-built_in_right; built_in_left:=0-built_in_left; ENDIF built_in_result:=0; REPEAT
-    temp:=built_in_left/2;
-    temp:=temp+temp;
-    IF temp != built_in_left THEN
-    built_in_result:=built_in_result+built_in_right;
-    ENDIF
-    built_in_right:=built_in_right+built_in_right;
-    built_in_left:=built_in_left/2;
-    UNTIL built_in_left = 0;
-
-END
-
-PROCEDURE built_in_div() IS
-    multiple, result_sign, temp
-BEGIN
-		IF built_in_right = 0 THEN
-			built_in_result := 0;
-		ELSE
-    IF built_in_left <= 0 THEN
-    built_in_left:= 0 - built_in_left;
-    result_sign := 1;
-    ELSE
-    result_sign := 0;
-    ENDIF
-
-    built_in_result := 0;
-    multiple := 1;
-
-    IF built_in_right <= 0 THEN
-    built_in_right:= 0 - built_in_right;
-    result_sign:= 1 - result_sign;
-    ENDIF
-
-    REPEAT
-        multiple:= multiple + multiple;
-        built_in_right:= built_in_right + built_in_right;
-    UNTIL built_in_right >= built_in_left;
-
-    REPEAT
-        IF built_in_left >= built_in_right THEN
-            built_in_left := built_in_left - built_in_right;
-            built_in_result := built_in_result + multiple;
-        ENDIF
-        built_in_right := built_in_right / 2;
-        multiple := multiple / 2;
-    UNTIL multiple = 0;
-
-    IF result_sign != 0 THEN
-        IF built_in_left != 0 THEN
-            built_in_result:=-1-built_in_result;
-        ELSE
-            built_in_result:=0-built_in_result;
-        ENDIF
-    ENDIF
-		ENDIF
-END
-PROCEDURE built_in_mod() IS
-    current_divisor, dividend_sign, divisor_sign
-BEGIN
-
-    IF built_in_left <= 0 THEN
-        built_in_left:=0-built_in_left;
-        dividend_sign:=1;
-    ELSE
-        dividend_sign:=0;
-    ENDIF
-
-    IF built_in_right <= 0 THEN
-        built_in_right:=0-built_in_right;
-        divisor_sign:=1;
-    ELSE
-        divisor_sign:=0;
-    ENDIF
-
-    current_divisor:=built_in_right;
-
-    REPEAT
-        current_divisor:=current_divisor+current_divisor;
-    UNTIL current_divisor > built_in_left;
-
-    REPEAT
-        current_divisor := current_divisor / 2;
-        IF built_in_left >= current_divisor THEN
-            built_in_left := built_in_left - current_divisor;
-        ENDIF
-    UNTIL built_in_left < built_in_right;
-
-    built_in_result:=built_in_left;
-
-    IF built_in_result != 0 THEN
-
-    IF dividend_sign != 0 THEN
-        built_in_result:=built_in_right-built_in_result;
-    ENDIF
+// pos returns the position of the current character.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		File:   l.file,
+		Offset: l.position,
+		Line:   l.currentLine,
+		Column: l.position - l.lineStart + 1,
+	}
+}
 
-    IF divisor_sign != 0 THEN
-        built_in_result:=built_in_result-built_in_right;
-    ENDIF
+func New(input string) *Lexer {
+	return NewFile("", input)
+}
 
-    ENDIF
-END ` + input
-	l := &Lexer{input: input, currentLine: 1}
+// NewFile creates a lexer for input read from the named file.
+func NewFile(file, input string) *Lexer {
+	l := &Lexer{input: input, currentLine: 1, file: file}
 	l.readChar()
 	return l
 }
 
+// File returns the name of the source the lexer is reading.
+func (l *Lexer) File() string {
+	return l.file
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
 		return 0
@@ -227,6 +164,10 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.currentLine++
+		l.lineStart = l.readPosition
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -244,6 +185,21 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
+// readString reads a double-quoted string, quotes included. A string must
+// end on the line it starts; ok is false if it does not.
+func (l *Lexer) readString() (literal string, ok bool) {
+	position := l.position
+	l.readChar() // opening quote
+	for l.ch != '"' {
+		if l.ch == '\n' || l.ch == 0 {
+			return l.input[position:l.position], false
+		}
+		l.readChar()
+	}
+	l.readChar() // closing quote
+	return l.input[position:l.position], true
+}
+
 func (l *Lexer) readPidentifier() string {
 	position := l.position
 	for isLowercaseLetter(l.ch) {
@@ -262,9 +218,6 @@ func (l *Lexer) readKeyword() string {
 
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		if l.ch == '\n' {
-			l.currentLine++
-		}
 		l.readChar()
 	}
 }
@@ -273,7 +226,6 @@ func (l *Lexer) skipComment() {
 	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
-	l.readChar() // Skip the newline character
 }
 
 func isDigit(ch byte) bool {
@@ -0,0 +1,270 @@
+package lint
+
+import (
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/callgraph"
+)
+
+// procedures returns the procedures declared in the file of the program.
+// Included files are not checked: their warnings are for their authors.
+func (p *pass) procedures() []*ast.Procedure {
+	var own []*ast.Procedure
+	for _, proc := range p.program.Procedures {
+		if proc != nil && proc.ProcHead.Name.Token.Pos.File == p.program.File {
+			own = append(own, proc)
+		}
+	}
+	return own
+}
+
+// bodies returns the commands of the procedures in the file of the program
+// and of main.
+func (p *pass) bodies() [][]ast.Command {
+	var bodies [][]ast.Command
+	for _, proc := range p.procedures() {
+		bodies = append(bodies, proc.Commands)
+	}
+	if p.program.Main != nil {
+		bodies = append(bodies, p.program.Main.Commands)
+	}
+	return bodies
+}
+
+func checkUnusedVariables(p *pass) {
+	for _, proc := range p.procedures() {
+		u := newUsages(proc.Declarations)
+		for _, contract := range append(proc.ProcHead.Requires, proc.ProcHead.Ensures...) {
+			u.read(contract.Condition)
+		}
+		u.commands(proc.Commands)
+		u.report(p)
+	}
+	if main := p.program.Main; main != nil {
+		u := newUsages(main.Declarations)
+		u.commands(main.Commands)
+		u.report(p)
+	}
+}
+
+// usage is how a local variable is used.
+type usage struct {
+	decl          *ast.Declaration
+	read, written bool
+}
+
+// usages follows the local variables of a procedure or of main through its
+// commands.
+type usages struct {
+	decls  []*usage
+	scopes []map[string]*usage // innermost last, a FOR iterator hides a variable
+}
+
+func newUsages(decls []ast.Declaration) *usages {
+	u := &usages{scopes: []map[string]*usage{{}}}
+	for i := range decls {
+		x := &usage{decl: &decls[i]}
+		u.decls = append(u.decls, x)
+		u.scopes[0][decls[i].Pidentifier.Value] = x
+	}
+	return u
+}
+
+func (u *usages) lookup(name string) *usage {
+	for i := len(u.scopes) - 1; i >= 0; i-- {
+		if x, ok := u.scopes[i][name]; ok {
+			return x
+		}
+	}
+	return nil
+}
+
+// read marks the variables in n as read.
+func (u *usages) read(n ast.Node) {
+	ast.Walk(n, func(n ast.Node) {
+		if id, ok := n.(*ast.Identifier); ok {
+			if x := u.lookup(id.Value); x != nil {
+				x.read = true
+			}
+		}
+	})
+}
+
+// write marks the variable id as written and its indices as read.
+func (u *usages) write(id *ast.Identifier) {
+	for _, index := range id.Indices {
+		u.read(index)
+	}
+	if x := u.lookup(id.Value); x != nil {
+		x.written = true
+	}
+}
+
+func (u *usages) commands(commands []ast.Command) {
+	for _, cmd := range commands {
+		switch cmd := cmd.(type) {
+		case *ast.AssignCommand:
+			u.read(&cmd.MathExpression)
+			u.write(&cmd.Identifier)
+		case *ast.ReadCommand:
+			u.write(&cmd.Identifier)
+		case *ast.IfCommand:
+			u.read(cmd.Condition)
+			u.commands(cmd.ThenCommands)
+			u.commands(cmd.ElseCommands)
+		case *ast.WhileCommand:
+			u.read(cmd.Condition)
+			u.commands(cmd.Commands)
+		case *ast.RepeatCommand:
+			u.commands(cmd.Commands)
+			u.read(cmd.Condition)
+		case *ast.ForCommand:
+			u.read(cmd.From)
+			u.read(cmd.To)
+			u.scopes = append(u.scopes, map[string]*usage{cmd.Iterator.Value: nil})
+			u.commands(cmd.Commands)
+			u.scopes = u.scopes[:len(u.scopes)-1]
+		case *ast.CaseCommand:
+			u.read(cmd.Value)
+			for _, arm := range cmd.Arms {
+				u.commands(arm.Commands)
+			}
+			u.commands(cmd.ElseCommands)
+		case *ast.AsmCommand:
+			// Any variable an instruction names is taken to be used.
+			for _, ins := range cmd.Instructions {
+				if operand, ok := ins.Operand.(*ast.Pidentifier); ok {
+					if x := u.lookup(operand.Value); x != nil {
+						x.read = true
+					}
+				}
+			}
+		default:
+			// A variable passed to a procedure is read as far as this
+			// check is concerned, whatever the procedure does with it.
+			u.read(cmd)
+		}
+	}
+}
+
+func (u *usages) report(p *pass) {
+	for _, x := range u.decls {
+		if x.read {
+			continue
+		}
+		noun := "variable"
+		if x.decl.IsTable {
+			noun = "array"
+		}
+		name := &x.decl.Pidentifier
+		if x.written {
+			p.warnf(name, "%s %s is assigned but never used", noun, name.Value)
+		} else {
+			p.warnf(name, "%s %s is declared but never used", noun, name.Value)
+		}
+	}
+}
+
+func checkUnusedProcedures(p *pass) {
+	for _, n := range callgraph.Build(p.program).Unreachable() {
+		proc, name := n.Proc, &n.Proc.ProcHead.Name
+		if name.Token.Pos.File != p.program.File {
+			continue
+		}
+		if proc.Returns {
+			p.warnf(name, "function %s is never called", name.Value)
+		} else {
+			p.warnf(name, "procedure %s is never called", name.Value)
+		}
+	}
+}
+
+func checkUnreachable(p *pass) {
+	for _, commands := range p.bodies() {
+		p.unreachable(commands)
+	}
+}
+
+// unreachable warns about the first of commands that control cannot reach,
+// and about those nested in the commands before it. It reports whether
+// control can leave commands at their end.
+func (p *pass) unreachable(commands []ast.Command) bool {
+	for i, cmd := range commands {
+		if !p.completes(cmd) {
+			if i+1 < len(commands) {
+				p.warnf(commands[i+1], "unreachable code")
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// completes warns about the unreachable commands nested in cmd and reports
+// whether control can go on to the command after it.
+func (p *pass) completes(cmd ast.Command) bool {
+	switch cmd := cmd.(type) {
+	case *ast.BreakCommand, *ast.ContinueCommand, *ast.ReturnCommand:
+		return false
+	case *ast.IfCommand:
+		then := p.unreachable(cmd.ThenCommands)
+		return p.unreachable(cmd.ElseCommands) || then
+	case *ast.CaseCommand:
+		completes := false
+		for _, arm := range cmd.Arms {
+			completes = p.unreachable(arm.Commands) || completes
+		}
+		return p.unreachable(cmd.ElseCommands) || completes
+	case *ast.WhileCommand:
+		p.unreachable(cmd.Commands)
+		value, ok := ast.ConstantCondition(cmd.Condition)
+		return !ok || !value || breaks(cmd.Commands)
+	case *ast.RepeatCommand:
+		p.unreachable(cmd.Commands)
+		value, ok := ast.ConstantCondition(cmd.Condition)
+		return !ok || value || breaks(cmd.Commands)
+	case *ast.ForCommand:
+		p.unreachable(cmd.Commands)
+	}
+	return true
+}
+
+// breaks reports whether commands hold a BREAK out of the loop they are the
+// body of.
+func breaks(commands []ast.Command) bool {
+	for _, cmd := range commands {
+		switch cmd := cmd.(type) {
+		case *ast.BreakCommand:
+			return true
+		case *ast.IfCommand:
+			if breaks(cmd.ThenCommands) || breaks(cmd.ElseCommands) {
+				return true
+			}
+		case *ast.CaseCommand:
+			for _, arm := range cmd.Arms {
+				if breaks(arm.Commands) {
+					return true
+				}
+			}
+			if breaks(cmd.ElseCommands) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkSelfAssign(p *pass) {
+	for _, commands := range p.bodies() {
+		for _, cmd := range commands {
+			ast.Walk(cmd, func(n ast.Node) {
+				assign, ok := n.(*ast.AssignCommand)
+				if !ok || assign.MathExpression.Right != nil {
+					return
+				}
+				if source, ok := assign.MathExpression.Left.(*ast.Identifier); ok && source.String() == assign.Identifier.String() {
+					p.warnf(assign, "%s is assigned to itself", source)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package lint warns about code that compiles but is likely wrong: variables
+// that are never used, procedures that are never called, commands that can
+// never run and assignments of a variable to itself.
+//
+// Every check has a name by which it is turned off or has its warnings made
+// errors. The warnings of sema, which finds them while checking the program,
+// are configured the same way.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/diag"
+)
+
+// Level is what a check reports with.
+type Level int
+
+const (
+	Warn Level = iota // the default
+	Off
+	Error
+)
+
+var levelNames = map[string]Level{"warning": Warn, "off": Off, "error": Error}
+
+func (l Level) String() string {
+	switch l {
+	case Warn:
+		return "warning"
+	case Off:
+		return "off"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Check is a kind of warning.
+type Check struct {
+	Name string // to configure it by
+	Code string // of its diagnostics
+	Doc  string
+	// run reports the warnings of the check in a program. It is nil for
+	// warnings reported by another phase.
+	run func(p *pass)
+}
+
+// Checks lists every check, in the order they run.
+var Checks = []*Check{
+	{Name: "uninitialized", Code: diag.WarnMaybeUninitialized, Doc: "variable read before it is assigned on some path"},
+	{Name: "index-range", Code: diag.WarnIndexRange, Doc: "array index that may fall outside the bounds"},
+	{Name: "unused-variable", Code: diag.WarnUnusedVariable, Doc: "variable that is never read", run: checkUnusedVariables},
+	{Name: "unused-procedure", Code: diag.WarnUnusedProcedure, Doc: "procedure that main never calls", run: checkUnusedProcedures},
+	{Name: "unreachable", Code: diag.WarnUnreachable, Doc: "command that can never run", run: checkUnreachable},
+	{Name: "self-assign", Code: diag.WarnSelfAssign, Doc: "variable assigned to itself", run: checkSelfAssign},
+}
+
+func lookup(name string) *Check {
+	for _, check := range Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return nil
+}
+
+// Config sets the level of each check. The zero Config reports every check
+// as a warning. It is a flag.Value taking "name=level", where name is a
+// check or "all" and level is warning, off or error.
+type Config struct {
+	levels map[string]Level
+}
+
+// Set configures a check from "name=level". A name alone turns it on.
+func (c *Config) Set(spec string) error {
+	name, levelName, ok := strings.Cut(spec, "=")
+	if !ok {
+		levelName = "warning"
+	}
+	level, ok := levelNames[levelName]
+	if !ok {
+		return fmt.Errorf("unknown warning level %q, want warning, off or error", levelName)
+	}
+	if name != "all" && lookup(name) == nil {
+		return fmt.Errorf("unknown warning %q", name)
+	}
+	if c.levels == nil {
+		c.levels = map[string]Level{}
+	}
+	if name == "all" {
+		for _, check := range Checks {
+			c.levels[check.Name] = level
+		}
+		return nil
+	}
+	c.levels[name] = level
+	return nil
+}
+
+func (c *Config) String() string {
+	var specs []string
+	for name, level := range c.levels {
+		specs = append(specs, name+"="+level.String())
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, ",")
+}
+
+// Level returns the level of the check named name.
+func (c *Config) Level(name string) Level {
+	return c.levels[name]
+}
+
+// Apply drops the diagnostics of the checks that are off from l and makes
+// errors of those set to error. Other diagnostics are kept as they are.
+func (c *Config) Apply(l diag.List) diag.List {
+	var out diag.List
+next:
+	for _, d := range l {
+		for _, check := range Checks {
+			if d.Code == check.Code && d.Severity == diag.Warning {
+				switch c.Level(check.Name) {
+				case Off:
+					continue next
+				case Error:
+					d.Severity = diag.Error
+				}
+			}
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// Run reports the warnings of every check that is not off in a program that
+// passed sema.Check, at the level config sets.
+func Run(program *ast.Program, config *Config) diag.List {
+	var warnings diag.List
+	for _, check := range Checks {
+		if check.run != nil && config.Level(check.Name) != Off {
+			check.run(&pass{check: check, program: program, warnings: &warnings})
+		}
+	}
+	return config.Apply(warnings)
+}
+
+// pass is a check being run on a program.
+type pass struct {
+	check    *Check
+	program  *ast.Program
+	warnings *diag.List
+}
+
+func (p *pass) warnf(at ast.Node, format string, args ...interface{}) {
+	p.warnings.Warnf(p.check.Code, diag.SpanOf(at), format, args...)
+}
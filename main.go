@@ -1,13 +1,35 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/Meduza3/imp/lint"
 	"github.com/Meduza3/imp/repl"
 )
 
+// searchPaths collects the directories given with repeated -I flags.
+type searchPaths []string
+
+func (s *searchPaths) String() string { return strings.Join(*s, string(os.PathListSeparator)) }
+func (s *searchPaths) Set(dir string) error {
+	*s = append(*s, dir)
+	return nil
+}
+
 func main() {
+	var includePaths searchPaths
+	flag.Var(&includePaths, "I", "add `dir` to the search path for INCLUDE (may be repeated)")
+	release := flag.Bool("release", false, "strip ASSERT, REQUIRES and ENSURES checks")
+	bounds := flag.Bool("bounds", false, "check array indices against the declared bounds at run time")
+	var warnings lint.Config
+	callGraph := flag.String("callgraph", "", "write the call graph as `format` dot or json to standard output instead of compiling")
+	flag.Var(&warnings, "W", "set a warning to `name=level`, where level is warning, off or error (may be repeated)")
+	flag.Parse()
+	args := flag.Args()
+
 	// user, err := user.Current()
 	// if err != nil {
 	// 	panic(err)
@@ -15,19 +37,19 @@ func main() {
 	// fmt.Printf("Witaj %s! To jest imp\n", user.Username)
 
 	// Check if a file is provided as a command-line argument
-	if len(os.Args) > 1 {
-		file, err := os.Open(os.Args[1])
+	if len(args) > 0 {
+		file, err := os.Open(args[0])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
 			os.Exit(1)
 		}
 		defer file.Close()
-		file2, err := os.Create(os.Args[2])
+		file2, err := os.Create(args[1])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
 			os.Exit(1)
 		}
-		repl.StartFile(file.Name(), file2) // Use the file as input
+		repl.StartFile(file.Name(), file2, repl.Options{SearchPaths: includePaths, Release: *release, BoundsCheck: *bounds, Warnings: warnings, CallGraph: *callGraph}) // Use the file as input
 	} else {
 		repl.Start(os.Stdin, os.Stdout) // Default to standard input
 	}
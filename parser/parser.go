@@ -1,10 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"unicode"
 
 	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/code"
+	"github.com/Meduza3/imp/diag"
 	"github.com/Meduza3/imp/token"
 
 	"github.com/Meduza3/imp/lexer"
@@ -12,76 +16,192 @@ import (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors diag.List
 
+	prevToken token.Token // the last one consumed, where a missing token goes
 	curToken  token.Token
 	peekToken token.Token
 }
 
-func (p *Parser) addError(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	p.errors = append(p.errors, msg)
+// errorf returns a syntax error located at the current token.
+func (p *Parser) errorf(format string, args ...interface{}) *diag.Diagnostic {
+	return diag.Errorf(diag.ErrSyntax, diag.TokenSpan(p.curToken), format, args...)
+}
+
+// expected returns the error for a current token that is not what.
+func (p *Parser) expected(what string) *diag.Diagnostic {
+	return p.errorf("expected %s, got %s", what, describe(p.curToken))
+}
+
+// missing returns the error for a current token that is not what, where
+// the token t was most likely left out. It suggests inserting t after the
+// previous token.
+func (p *Parser) missing(t token.TokenType, what string) *diag.Diagnostic {
+	text := string(t)
+	if unicode.IsLetter(rune(text[0])) {
+		text = " " + text
+	}
+	return p.expected(what).WithFix("insert "+describe(token.Token{Type: t, Literal: string(t)}), diag.At(p.prevToken.End()), text)
+}
+
+// errReported is returned by constructs that have already reported their
+// error. Callers still resynchronize but do not report it again.
+var errReported = errors.New("syntax error already reported")
+
+// report records err. Errors that are not diagnostics yet are placed at the
+// current token.
+func (p *Parser) report(err error) {
+	if err == errReported {
+		return
+	}
+	var d *diag.Diagnostic
+	if !errors.As(err, &d) {
+		d = diag.Errorf(diag.ErrSyntax, diag.TokenSpan(p.curToken), "%v", err)
+	}
+	p.add(d)
+}
+
+// add records d unless the last error is at the same position. The parser
+// has not moved past the offending token since, so d would only be a
+// consequence of that error.
+func (p *Parser) add(d *diag.Diagnostic) {
+	if n := len(p.errors); n > 0 && p.errors[n-1].Span.Start == d.Span.Start {
+		return
+	}
+	p.errors.Add(d)
+}
+
+// describe names a token the way error messages quote it.
+func describe(tok token.Token) string {
+	switch tok.Type {
+	case token.EOF:
+		return "end of file"
+	case token.NUM:
+		return "number " + tok.Literal
+	case token.PIDENTIFIER:
+		return "identifier " + tok.Literal
+	case token.STRING:
+		return "string " + tok.Literal
+	}
+	return fmt.Sprintf("'%s'", tok.Literal)
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l}
 	p.nextToken()
 	p.nextToken()
 
 	return p
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() diag.List {
 	return p.errors
 }
 
 func (p *Parser) peekError(t token.TokenType) error {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
-	return fmt.Errorf(msg)
+	err := diag.Errorf(diag.ErrSyntax, diag.TokenSpan(p.peekToken), "expected next token to be %s, got %s instead", t, describe(p.peekToken))
+	p.add(err)
+	return err
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
 	// fmt.Printf("curToken = %v, peekToken = %v\n", p.curToken, p.peekToken)
 }
 
-// Parse program is the first function that is being called when you start to parse the program
+// Parse program is the first function that is being called when you start to parse the program.
+// Syntax errors do not stop it: they are collected in Errors and the returned
+// program keeps whatever could be parsed, with error nodes in place of broken
+// commands.
 func (p *Parser) ParseProgram() *ast.Program {
-	// fmt.Printf("in ParseProgram: %v\n", p.curToken)
 	//Currently the main is a list of commands
-	token := token.Token{Literal: "PROGRAM_ALL", Type: token.PROGRAM_ALL}
+	tok := token.Token{Literal: "PROGRAM_ALL", Type: token.PROGRAM_ALL}
+	includes := p.parseIncludes()
 	procedures := p.parseProcedures()
-	//time.Sleep(10 * time.Second)
 	main, err := p.parseMain()
 	if err != nil {
-		log.Fatalf("failed to parse main: %v", err)
+		p.report(err)
+	} else {
+		p.nextToken() // eat 'END'
+		if !p.curTokenIs(token.EOF) {
+			p.report(p.expected("end of file"))
+		}
 	}
-	program := &ast.Program{Token: token, Procedures: procedures, Main: main}
+	program := &ast.Program{Token: tok, File: p.l.File(), Includes: includes, Procedures: procedures, Main: main}
 	return program
 }
 
+// ParseFragment parses a unit made only of procedures, such as the prelude
+// or an included library. The returned program has no Main.
+func (p *Parser) ParseFragment() *ast.Program {
+	tok := token.Token{Literal: "PROGRAM_ALL", Type: token.PROGRAM_ALL}
+	includes := p.parseIncludes()
+	procedures := p.parseProcedures()
+	if !p.curTokenIs(token.EOF) {
+		p.report(p.expected("PROCEDURE or end of file"))
+	}
+	return &ast.Program{Token: tok, File: p.l.File(), Includes: includes, Procedures: procedures}
+}
+
+// parseIncludes parses the INCLUDE directives at the top of a file.
+func (p *Parser) parseIncludes() []*ast.Include {
+	var includes []*ast.Include
+	for p.curTokenIs(token.INCLUDE) {
+		include, err := p.parseInclude()
+		if err != nil {
+			p.report(err)
+			for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.INCLUDE) && !p.atSectionEnd() {
+				p.nextToken()
+			}
+			if p.curTokenIs(token.SEMICOLON) {
+				p.nextToken()
+			}
+			continue
+		}
+		includes = append(includes, include)
+	}
+	return includes
+}
+
+func (p *Parser) parseInclude() (*ast.Include, error) {
+	include := &ast.Include{Token: p.curToken}
+	p.nextToken() // eat 'INCLUDE'
+	if !p.curTokenIs(token.STRING) {
+		return nil, p.expected("file name in double quotes")
+	}
+	path, err := strconv.Unquote(p.curToken.Literal)
+	if err != nil || path == "" {
+		return nil, p.errorf("invalid file name %s", p.curToken.Literal)
+	}
+	include.Path = path
+	include.PathTok = p.curToken
+	p.nextToken()
+	if !p.curTokenIs(token.SEMICOLON) {
+		return nil, p.missing(token.SEMICOLON, "';'")
+	}
+	include.EndToken = p.curToken
+	p.nextToken()
+	return include, nil
+}
+
 func (p *Parser) parseMain() (*ast.Main, error) {
-	// fmt.Printf("in parseMain. Token = %s\n", p.curToken.Type)
-	main := ast.Main{}
+	main := &ast.Main{}
 	if !p.curTokenIs(token.PROGRAM) {
-		return nil, fmt.Errorf("line %d: expected PROGRAM got %s", p.curToken.Line, p.curToken.Type)
+		return nil, p.expected("PROGRAM")
 	}
 	main.Token = p.curToken
-	p.nextToken() // curToken = IS
-	if !p.curTokenIs(token.IS) {
-		return nil, fmt.Errorf("line %d: expected IS got %s", p.curToken.Line, p.curToken.Type)
-	}
-	p.nextToken() // curToken = BEGIN
-	if !p.curTokenIs(token.BEGIN) {
-		decl := p.parseDeclarations()
-		main.Declarations = *decl
-	}
-	p.nextToken() // eat 'BEGIN'
-	commands := p.parseCommandsUntil(token.END)
-	main.Commands = *commands
-	return &main, nil
+	p.nextToken()
+	p.expect(token.IS, "IS")
+	main.Constants = p.parseConstants()
+	main.Declarations = p.parseDeclarations()
+	main.Commands = p.parseBody()
+	if !p.curTokenIs(token.END) {
+		return main, p.expected("END")
+	}
+	main.EndToken = p.curToken
+	return main, nil
 }
 
 func (p *Parser) parsePidentifier() ast.Pidentifier {
@@ -95,43 +215,126 @@ func (p *Parser) parsePidentifier() ast.Pidentifier {
 	return pid
 }
 
-func (p *Parser) parseDeclarations() *[]ast.Declaration {
-	// fmt.Printf("in parseDeclarations. Token=%s\n", p.curToken.Type)
-	var decl = []ast.Declaration{}
-	for !p.curTokenIs(token.BEGIN) {
-		if !p.peekTokenIs(token.LBRACKET) { // not a table
-			pid := p.parsePidentifier()
-			decl = append(decl, ast.Declaration{IsTable: false, Pidentifier: pid})
-		} else {
-			pid := p.parsePidentifier() // Consumes PIDENTIFIER, curToken becomes '['
-			p.nextToken()               // Consume '[', curToken now at start of lower bound
-
-			// PIDENTIFIER = curToken
-			from, err := p.parseNumberWithOptionalMinus()
-			if err != nil {
-				p.addError(err.Error())
-				return &decl
+// parseConstants parses the optional "CONST name = value, ...;" section in
+// front of the declarations.
+func (p *Parser) parseConstants() []ast.ConstDeclaration {
+	constants := []ast.ConstDeclaration{}
+	if !p.curTokenIs(token.CONST) {
+		return constants
+	}
+	p.nextToken() // eat 'CONST'
+	for {
+		c, err := p.parseConstDeclaration()
+		if err != nil {
+			p.report(err)
+			for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.BEGIN) && !p.atSectionEnd() {
+				p.nextToken()
 			}
-			p.nextToken() // num = curtoken
-			to, err := p.parseNumberWithOptionalMinus()
-			if err != nil {
-				p.addError(err.Error())
-				return &decl
+			if p.curTokenIs(token.SEMICOLON) {
+				p.nextToken()
 			}
-			p.nextToken() // ] = curtoken
-			decl = append(decl, ast.Declaration{IsTable: true, Pidentifier: pid, From: from, To: to})
+			return constants
+		}
+		constants = append(constants, *c)
+		if !p.curTokenIs(token.COMMA) {
+			break
 		}
+		p.nextToken() // eat ','
+	}
+	p.expect(token.SEMICOLON, "',' or ';' after constants")
+	return constants
+}
+
+func (p *Parser) parseConstDeclaration() (*ast.ConstDeclaration, error) {
+	if !p.curTokenIs(token.PIDENTIFIER) {
+		return nil, p.expected("constant name")
+	}
+	name := p.parsePidentifier()
+	if !p.curTokenIs(token.EQUALS) {
+		return nil, p.expected("'='")
+	}
+	p.nextToken() // eat '='
+	value, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.ConstDeclaration{Name: name, Value: value}, nil
+}
+
+// parseDeclarations parses the declarations before BEGIN and consumes BEGIN.
+func (p *Parser) parseDeclarations() []ast.Declaration {
+	var decl = []ast.Declaration{}
+	for !p.curTokenIs(token.BEGIN) {
+		d, err := p.parseDeclaration()
+		if err != nil {
+			p.report(err)
+			break
+		}
+		decl = append(decl, *d)
 
 		// Check if the next token is a comma before consuming it
-		if p.curTokenIs(token.COMMA) {
-			p.nextToken() // consume the comma
-		} else {
+		if !p.curTokenIs(token.COMMA) {
 			// No comma means we've reached the end of declarations
+			if !p.curTokenIs(token.BEGIN) {
+				p.report(p.expected("',' or BEGIN"))
+			}
 			break
 		}
-		// time.Sleep(300 * time.Millisecond)
+		p.nextToken() // consume the comma
+	}
+	// Without BEGIN the first command was skipped up to its ';'; resume
+	// after it.
+	if p.skipTo(token.BEGIN) || p.curTokenIs(token.SEMICOLON) {
+		p.nextToken() // eat 'BEGIN'
 	}
-	return &decl
+	return decl
+}
+
+func (p *Parser) parseDeclaration() (*ast.Declaration, error) {
+	if !p.curTokenIs(token.PIDENTIFIER) {
+		return nil, p.expected("identifier in declarations")
+	}
+	pid := p.parsePidentifier()
+	if !p.curTokenIs(token.LBRACKET) { // not a table
+		return &ast.Declaration{IsTable: false, Pidentifier: pid}, nil
+	}
+	p.nextToken() // Consume '[', curToken now at start of lower bound
+	dims, endToken, err := p.parseBounds()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Declaration{IsTable: true, Pidentifier: pid, From: dims[0].From, To: dims[0].To, Inner: dims[1:], EndToken: endToken}, nil
+}
+
+// parseBounds parses the comma separated "from:to" ranges of an array
+// declaration up to and including the closing ']', which it returns.
+func (p *Parser) parseBounds() ([]ast.Bounds, token.Token, error) {
+	dims := []ast.Bounds{}
+	for {
+		from, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, p.curToken, err
+		}
+		if !p.curTokenIs(token.COLON) {
+			return nil, p.curToken, p.expected("':'")
+		}
+		p.nextToken()
+		to, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, p.curToken, err
+		}
+		dims = append(dims, ast.Bounds{From: from, To: to})
+		if !p.curTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken() // eat ','
+	}
+	if !p.curTokenIs(token.RBRACKET) {
+		return nil, p.curToken, p.expected("',' or ']'")
+	}
+	endToken := p.curToken
+	p.nextToken()
+	return dims, endToken, nil
 }
 
 func (p *Parser) parseNumberWithOptionalMinus() (ast.NumberLiteral, error) {
@@ -143,21 +346,21 @@ func (p *Parser) parseNumberWithOptionalMinus() (ast.NumberLiteral, error) {
 		p.nextToken() // Consume '-'
 
 		if !p.curTokenIs(token.NUM) {
-			return ast.NumberLiteral{}, fmt.Errorf("expected number after '-' at line %d", minusToken.Line)
+			return ast.NumberLiteral{}, p.expected("number after '-'")
 		}
 
 		// Combine minus and number into one literal
 		numberToken = token.Token{
 			Type:    token.NUM,
 			Literal: "-" + p.curToken.Literal,
-			Line:    p.curToken.Line,
+			Pos:     minusToken.Pos,
 		}
 		p.nextToken() // Consume the number
 	} else if p.curTokenIs(token.NUM) {
 		numberToken = p.curToken
 		p.nextToken()
 	} else {
-		return ast.NumberLiteral{}, fmt.Errorf("expected number at line %d", p.curToken.Line)
+		return ast.NumberLiteral{}, p.expected("number")
 	}
 
 	return ast.NumberLiteral{
@@ -179,6 +382,12 @@ func (p *Parser) ParseCommand() (ast.Command, error) {
 		return p.parseAssignCommand() // or function call!
 	case token.IF:
 		return p.parseIfCommand()
+	case token.CASE:
+		return p.parseCaseCommand()
+	case token.ASM:
+		return p.parseAsmCommand()
+	case token.ASSERT:
+		return p.parseAssertCommand()
 	case token.WHILE:
 		return p.parseWhileCommand()
 	case token.REPEAT:
@@ -189,317 +398,551 @@ func (p *Parser) ParseCommand() (ast.Command, error) {
 		return p.parseReadCommand()
 	case token.WRITE:
 		return p.parseWriteCommand()
+	case token.RETURN:
+		return p.parseReturnCommand()
+	case token.BREAK:
+		tok, end, err := p.parseLoopControl()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.BreakCommand{Token: tok, EndToken: end}, nil
+	case token.CONTINUE:
+		tok, end, err := p.parseLoopControl()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ContinueCommand{Token: tok, EndToken: end}, nil
 	default:
-		return nil, fmt.Errorf("failed to parseCommand, no matching command for token: %v", p.curToken.Type)
+		return nil, p.expected("command")
+	}
+}
+
+// parseContracts parses the REQUIRES and ENSURES clauses between a
+// procedure head and IS.
+func (p *Parser) parseContracts(head *ast.ProcHead) error {
+	for p.curTokenIs(token.REQUIRES) || p.curTokenIs(token.ENSURES) {
+		contract := ast.Contract{Token: p.curToken}
+		p.nextToken()
+		cond, err := p.parseCondition()
+		if err != nil {
+			return err
+		}
+		contract.Condition = cond
+		if contract.Token.Type == token.REQUIRES {
+			head.Requires = append(head.Requires, contract)
+		} else {
+			head.Ensures = append(head.Ensures, contract)
+		}
 	}
+	return nil
 }
 
-func (p *Parser) parseProcCallCommand() (*ast.ProcCallCommand, error) {
+func (p *Parser) parseAssertCommand() (ast.Command, error) {
+	tok := p.curToken
+	p.nextToken() // eat 'ASSERT'
+	cond, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		return nil, p.missing(token.SEMICOLON, "';'")
+	}
+	endToken := p.curToken
+	p.nextToken() // eat ';'
+	return &ast.AssertCommand{Token: tok, Condition: cond, EndToken: endToken}, nil
+}
+
+// parseAsmCommand parses ASM ... ENDASM. A malformed instruction is
+// reported and skipped up to its ';' so the rest of the block is still
+// checked.
+func (p *Parser) parseAsmCommand() (ast.Command, error) {
+	cmd := &ast.AsmCommand{Token: p.curToken}
+	p.nextToken() // eat 'ASM'
+	for !p.curTokenIs(token.ENDASM) {
+		if p.atSectionEnd() {
+			return nil, p.expected("ENDASM")
+		}
+		ins, err := p.parseAsmInstruction()
+		if err != nil {
+			p.report(err)
+			for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.ENDASM) && !p.atSectionEnd() {
+				p.nextToken()
+			}
+			if p.curTokenIs(token.SEMICOLON) {
+				p.nextToken()
+			}
+			continue
+		}
+		cmd.Instructions = append(cmd.Instructions, *ins)
+	}
+	cmd.EndToken = p.curToken
+	p.nextToken() // eat 'ENDASM'
+	return cmd, nil
+}
+
+func (p *Parser) parseAsmInstruction() (*ast.AsmInstruction, error) {
+	ins := &ast.AsmInstruction{}
+	for p.curTokenIs(token.PIDENTIFIER) && p.peekTokenIs(token.COLON) {
+		ins.Labels = append(ins.Labels, p.parsePidentifier())
+		p.nextToken() // eat ':'
+	}
+	if len(ins.Labels) > 0 && p.curTokenIs(token.ENDASM) {
+		return ins, nil
+	}
+	if !p.curTokenIs(token.OPCODE) {
+		return nil, p.expected("machine instruction")
+	}
+	ins.Opcode = p.curToken
+	p.nextToken()
+	if operands, _ := code.Operands(ins.Opcode.Literal); operands == 1 {
+		switch p.curToken.Type {
+		case token.PIDENTIFIER:
+			name := p.parsePidentifier()
+			ins.Operand = &name
+		case token.NUM, token.MINUS:
+			number, err := p.parseNumberWithOptionalMinus()
+			if err != nil {
+				return nil, err
+			}
+			ins.Operand = &number
+		default:
+			return nil, p.expected("operand of " + ins.Opcode.Literal)
+		}
+	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		return nil, p.missing(token.SEMICOLON, "';'")
+	}
+	ins.EndToken = p.curToken
+	p.nextToken()
+	return ins, nil
+}
+
+func (p *Parser) parseProcCallCommand() (ast.Command, error) {
 	// fmt.Printf("in parseProcCallCommand. curToken=%v\n", p.curToken)
 	procCallToken := p.curToken
 	name := p.parsePidentifier()
 	if !p.curTokenIs(token.LPAREN) {
-		return nil, fmt.Errorf("line %d expected '(' got %s", p.curToken.Line, p.curToken.Type)
+		return nil, p.expected("'('")
 	}
 	p.nextToken()
 	args, err := p.parseArgs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse arguments in proccall: %v", err)
-	}
-	if !p.curTokenIs(token.RPAREN) {
-		return nil, fmt.Errorf("line %d expected ')' got %s", p.curToken.Line, p.curToken.Type)
+		return nil, err
 	}
-	p.nextToken()
+	p.nextToken() // eat ')'
 	if !p.curTokenIs(token.SEMICOLON) {
-		return nil, fmt.Errorf("line %d expected ';' got %s", p.curToken.Line, p.curToken.Type)
+		return nil, p.missing(token.SEMICOLON, "';'")
 	}
+	endToken := p.curToken
 	p.nextToken()
 	return &ast.ProcCallCommand{
-		Token: procCallToken,
-		Name:  name,
-		Args:  *args,
+		Token:    procCallToken,
+		Name:     name,
+		Args:     args,
+		EndToken: endToken,
 	}, nil
 }
 
-func (p *Parser) parseArgs() (*[]ast.Pidentifier, error) {
-	args := []ast.Pidentifier{}
-	if p.curTokenIs(token.RPAREN) {
-		return &args, nil
-	}
-	// fmt.Printf("in parseArgs. curToken=%v\n", p.curToken)
-	if !p.curTokenIs(token.PIDENTIFIER) {
-		return nil, fmt.Errorf("failed parsing proccall line %d: expected pidentifier in args, got %s", p.curToken.Line, p.curToken.Type)
-	}
-
-	pid := p.parsePidentifier()
-	args = append(args, pid)
-
-	for p.curTokenIs(token.COMMA) {
-		p.nextToken() // eat ','
-		if !p.curTokenIs(token.PIDENTIFIER) {
-			return nil, fmt.Errorf("failed parsing proccall line %d: expected pidentifier in args, got %s", p.curToken.Line, p.curToken.Type)
+// parseArgs parses the arguments of a call up to the closing ')', which
+// is left as the current token.
+func (p *Parser) parseArgs() ([]ast.Value, error) {
+	args := []ast.Value{}
+	for !p.curTokenIs(token.RPAREN) {
+		if len(args) > 0 {
+			if !p.curTokenIs(token.COMMA) {
+				return nil, p.expected("',' or ')'")
+			}
+			p.nextToken() // eat ','
 		}
-
-		pid = p.parsePidentifier()
-		args = append(args, pid)
+		arg, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
 	}
-
-	return &args, nil
+	return args, nil
 }
 
-func (p *Parser) parseWhileCommand() (*ast.WhileCommand, error) {
+func (p *Parser) parseWhileCommand() (ast.Command, error) {
 	whileComm := &ast.WhileCommand{Token: p.curToken}
 	p.nextToken() // Consume 'WHILE'
 
 	condition, err := p.parseCondition()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse condition: %v", err)
-	}
-	whileComm.Condition = *condition
-
-	if !p.curTokenIs(token.DO) {
-		return nil, fmt.Errorf("expected DO after condition, got %v", p.curToken)
+		condition = p.badCondition()
+		if !p.recoverTo(err, token.DO) {
+			return nil, errReported
+		}
 	}
-	p.nextToken() // Consume 'DO'
+	whileComm.Condition = condition
+	p.expect(token.DO, "DO after condition")
 
 	// Parse commands until ENDWHILE
-	whileComm.Commands = *p.parseCommandsUntil(token.ENDWHILE)
+	whileComm.Commands = p.parseBlock()
 
 	if !p.curTokenIs(token.ENDWHILE) {
-		return nil, fmt.Errorf("expected ENDWHILE, got %v", p.curToken)
+		return whileComm, p.expected("ENDWHILE")
 	}
+	whileComm.EndToken = p.curToken
 	p.nextToken() // Consume ENDWHILE to advance to the next token
 
 	return whileComm, nil
 }
 
-func (p *Parser) parseRepeatCommand() (*ast.RepeatCommand, error) {
-	repComm := &ast.RepeatCommand{}
-	repToken := p.curToken
+func (p *Parser) parseRepeatCommand() (ast.Command, error) {
+	repComm := &ast.RepeatCommand{Token: p.curToken}
 	p.nextToken()
-	commands := p.parseCommandsUntil(token.UNTIL)
+	repComm.Commands = p.parseBlock()
+	if !p.curTokenIs(token.UNTIL) {
+		return repComm, p.expected("UNTIL")
+	}
 	p.nextToken()
 	condition, err := p.parseCondition()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse condition: %v", err)
+		condition = p.badCondition()
+		if !p.recoverTo(err, token.SEMICOLON) {
+			return repComm, errReported
+		}
 	}
+	repComm.Condition = condition
 	if !p.curTokenIs(token.SEMICOLON) {
-		return nil, fmt.Errorf("failed to parse for line %d: expected ';' got %s", p.curToken.Line, p.curToken.Type)
+		return repComm, p.missing(token.SEMICOLON, "';'")
 	}
+	repComm.EndToken = p.curToken
 	p.nextToken()
-	repComm.Token = repToken
-	repComm.Commands = *commands
-	repComm.Condition = *condition
 	return repComm, nil
 }
 
-func (p *Parser) parseForCommand() (*ast.ForCommand, error) {
-	forComm := &ast.ForCommand{}
-	forToken := p.curToken
-	p.nextToken()               // Eat 'FOR'
-	pid := p.parsePidentifier() // Eat 'i'
+func (p *Parser) parseForCommand() (ast.Command, error) {
+	forComm := &ast.ForCommand{Token: p.curToken}
+	p.nextToken() // Eat 'FOR'
+	if err := p.parseForHeader(forComm); err != nil {
+		bad := &ast.BadExpression{Token: p.curToken}
+		if forComm.From == nil {
+			forComm.From = bad
+		}
+		if forComm.To == nil {
+			forComm.To = bad
+		}
+		if !p.recoverTo(err, token.DO) {
+			return nil, errReported
+		}
+	}
+	p.expect(token.DO, "DO")
+	forComm.Commands = p.parseBlock()
+	if !p.curTokenIs(token.ENDFOR) {
+		return forComm, p.expected("ENDFOR")
+	}
+	forComm.EndToken = p.curToken
+	p.nextToken() // eat 'ENDFOR'
+	return forComm, nil
+}
+
+// parseForHeader parses "i FROM expression TO|DOWNTO expression" into forComm.
+func (p *Parser) parseForHeader(forComm *ast.ForCommand) error {
+	if !p.curTokenIs(token.PIDENTIFIER) {
+		return p.expected("iterator name")
+	}
+	forComm.Iterator = p.parsePidentifier() // Eat 'i'
 	if !p.curTokenIs(token.FROM) {
-		return nil, fmt.Errorf("failed to parse for line %d: expected FROM got %s", p.curToken.Line, p.curToken.Type)
+		return p.expected("FROM")
 	}
-	p.nextToken()                  // Eat "FROM"
-	valFrom, err := p.parseValue() //Eat val
+	p.nextToken()                             // Eat "FROM"
+	valFrom, err := p.parseExpression(LOWEST) //Eat val
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse for: failed to parse value at line %d: %v", p.curToken.Line, err)
+		return err
 	}
+	forComm.From = valFrom
 	if p.curToken.Type == token.TO {
 		forComm.IsDownTo = false
 	} else if p.curToken.Type == token.DOWNTO {
 		forComm.IsDownTo = true
 	} else {
-		return nil, fmt.Errorf("failed to parse for line %d: expected DOWNTO or TO got %s", p.curToken.Line, p.curToken.Type)
+		return p.expected("TO or DOWNTO")
 	}
 	p.nextToken() //eat TO/DOWNTO
-	valTo, err := p.parseValue()
+	valTo, err := p.parseExpression(LOWEST)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse for: failed to parse value at line %d: %v", p.curToken.Line, err)
-	}
-	if !p.curTokenIs(token.DO) {
-		return nil, fmt.Errorf("failed to parse for line %d: expected DO got %s", p.curToken.Line, p.curToken.Type)
+		return err
 	}
-	p.nextToken() // eat 'DO'
-	commands := p.parseCommandsUntil(token.ENDFOR)
-	p.nextToken() // eat 'ENDFOR'
-	forComm.Token = forToken
-	forComm.Iterator = pid
-	forComm.From = valFrom
 	forComm.To = valTo
-	forComm.Commands = *commands
-	return forComm, nil
+	return nil
 }
 
-func (p *Parser) parseReadCommand() (*ast.ReadCommand, error) {
+func (p *Parser) parseReadCommand() (ast.Command, error) {
 	// fmt.Printf("in parseWriteCommand: %v\n", p.curToken)
 	tok := p.curToken
 	p.nextToken() // Skip "READ". p.curToken now holds the value to read
 	value, err := p.parseIdentifier()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse read command: failed to parse identifier: %v", err)
+		return nil, err
 	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		return nil, p.missing(token.SEMICOLON, "';'")
+	}
+	endToken := p.curToken
 	p.nextToken() // skip ';'
 	return &ast.ReadCommand{
 		Token:      tok,
 		Identifier: *value,
+		EndToken:   endToken,
 	}, nil
 }
 
-func (p *Parser) parseWriteCommand() (*ast.WriteCommand, error) {
+func (p *Parser) parseWriteCommand() (ast.Command, error) {
 	// fmt.Printf("in parseWriteCommand: %v\n", p.curToken)
 	tok := p.curToken
 	p.nextToken() // Skip "WRITE". p.curToken now holds the value to write
-	value, err := p.parseValue()
+	value, err := p.parseExpression(LOWEST)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse write command: failed to parse value: %v", err)
+		return nil, err
 	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		return nil, p.missing(token.SEMICOLON, "';'")
+	}
+	endToken := p.curToken
 	p.nextToken() // skip ';'
 	return &ast.WriteCommand{
-		Token: tok,
-		Value: value,
+		Token:    tok,
+		Value:    value,
+		EndToken: endToken,
+	}, nil
+}
+
+func (p *Parser) parseReturnCommand() (ast.Command, error) {
+	tok := p.curToken
+	p.nextToken() // Skip "RETURN"
+	value, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		return nil, p.missing(token.SEMICOLON, "';'")
+	}
+	endToken := p.curToken
+	p.nextToken() // skip ';'
+	return &ast.ReturnCommand{
+		Token:    tok,
+		Value:    value,
+		EndToken: endToken,
 	}, nil
 }
 
-func (p *Parser) parseAssignCommand() (*ast.AssignCommand, error) {
+// parseLoopControl parses BREAK or CONTINUE and its ';'.
+func (p *Parser) parseLoopControl() (token.Token, token.Token, error) {
+	tok := p.curToken
+	p.nextToken() // Skip "BREAK" or "CONTINUE"
+	if !p.curTokenIs(token.SEMICOLON) {
+		return tok, tok, p.missing(token.SEMICOLON, "';'")
+	}
+	endToken := p.curToken
+	p.nextToken() // skip ';'
+	return tok, endToken, nil
+}
+
+func (p *Parser) parseAssignCommand() (ast.Command, error) {
 	// fmt.Printf("in parseAssignCommand: %v\n", p.curToken)
 
 	identifier, err := p.parseIdentifier()
 	if err != nil {
-		p.addError("failed to parse assign command: %v", err)
 		return nil, err
 	}
 
 	if !p.curTokenIs(token.ASSIGN) {
-		errMsg := "expected ASSIGN token ':=' but got %v"
-		p.addError(errMsg, p.curToken.Type)
-		return nil, fmt.Errorf(errMsg, p.curToken.Type)
+		return nil, p.expected("':='")
 	}
 	assignToken := p.curToken
 	p.nextToken() // consume ':='
 
 	mathExpression, err := p.parseMathExpression()
 	if err != nil {
-		p.addError("failed to parse assign command: expression error: %v", err)
 		return nil, err
 	}
 
 	if !p.curTokenIs(token.SEMICOLON) {
-		errMsg := "expected semicolon ';' but got %v"
-		p.addError(errMsg, p.curToken.Type)
-		return nil, fmt.Errorf(errMsg, p.curToken.Type)
+		return nil, p.missing(token.SEMICOLON, "';'")
 	}
+	endToken := p.curToken
 	p.nextToken() // consume ';'
 
 	return &ast.AssignCommand{
 		Identifier:     *identifier,
 		Token:          assignToken,
 		MathExpression: *mathExpression,
+		EndToken:       endToken,
 	}, nil
 }
 
-func (p *Parser) parseIfCommand() (*ast.IfCommand, error) {
-	// fmt.Printf("in parseIfCommand: %v\n", p.curToken)
-	ifCmd := ast.IfCommand{Token: p.curToken}
+func (p *Parser) parseIfCommand() (ast.Command, error) {
+	ifCmd := &ast.IfCommand{Token: p.curToken}
 	p.nextToken()                        // Eat "IF"
 	condition, err := p.parseCondition() // Eat conditon
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse condition: %v", err)
-	}
-	ifCmd.Condition = *condition
-	if !p.curTokenIs(token.THEN) {
-		return nil, fmt.Errorf("parseIfCommand: expected THEN, got %v", p.curToken)
+		condition = p.badCondition()
+		if !p.recoverTo(err, token.THEN) {
+			return nil, errReported
+		}
 	}
-	p.nextToken()                                                       // skip THEN
-	ifCmd.ThenCommands = *p.parseCommandsUntil(token.ELSE, token.ENDIF) // Eat commands
+	ifCmd.Condition = condition
+	p.expect(token.THEN, "THEN")
+	ifCmd.ThenCommands = p.parseBlock() // Eat commands
 	if p.curToken.Type == token.ELSE {
-		p.nextToken()                                           // Eat "ELSE"
-		ifCmd.ElseCommands = *p.parseCommandsUntil(token.ENDIF) // Eat commands
+		p.nextToken()                       // Eat "ELSE"
+		ifCmd.ElseCommands = p.parseBlock() // Eat commands
 	}
 	if !p.curTokenIs(token.ENDIF) {
-		return nil, fmt.Errorf("parseIfCommand: expected ENDIF, got %v", p.curToken)
+		return ifCmd, p.expected("ENDIF")
 	}
+	ifCmd.EndToken = p.curToken
 	p.nextToken() // Eat "ENDIF"
-	return &ifCmd, nil
+	return ifCmd, nil
 }
 
-func (p *Parser) parseCommands() *[]ast.Command {
-	// fmt.Printf("in parseCommands: %v\n", p.curToken)
-	var commands = []ast.Command{}
-	for p.curToken.Type != token.EOF {
-		command, err := p.ParseCommand()
+func (p *Parser) parseCaseCommand() (ast.Command, error) {
+	caseCmd := &ast.CaseCommand{Token: p.curToken}
+	p.nextToken() // Eat "CASE"
+	value, err := p.parseExpression(LOWEST)
+	if err != nil {
+		value = &ast.BadExpression{Token: p.curToken}
+		if !p.recoverTo(err, token.OF) {
+			return nil, errReported
+		}
+	}
+	caseCmd.Value = value
+	p.expect(token.OF, "OF")
+	for p.atCaseLabel() {
+		arm := ast.CaseArm{}
+		labels, err := p.parseCaseLabels()
+		arm.Labels = labels
+		if err == nil || p.recoverTo(err, token.COLON) {
+			p.expect(token.COLON, "':' after CASE labels")
+		}
+		arm.Commands = p.parseBlockUntil(p.atCaseLabel)
+		caseCmd.Arms = append(caseCmd.Arms, arm)
+	}
+	if p.curTokenIs(token.ELSE) {
+		p.nextToken() // Eat "ELSE"
+		caseCmd.ElseCommands = p.parseBlock()
+	}
+	if !p.curTokenIs(token.ENDCASE) {
+		return caseCmd, p.expected("ENDCASE")
+	}
+	caseCmd.EndToken = p.curToken
+	p.nextToken() // Eat "ENDCASE"
+	return caseCmd, nil
+}
+
+// parseCaseLabels parses the comma separated values and ranges in front of
+// a CASE arm.
+func (p *Parser) parseCaseLabels() ([]ast.CaseLabel, error) {
+	labels := []ast.CaseLabel{}
+	for {
+		from, err := p.parseNumberWithOptionalMinus()
 		if err != nil {
-			p.errors = append(p.errors, fmt.Sprintf("failed to parse command: %v", err))
-			break
+			return labels, err
 		}
-		commands = append(commands, command)
+		label := ast.CaseLabel{From: from, To: from}
+		if p.curTokenIs(token.RANGE) {
+			p.nextToken() // Eat ".."
+			to, err := p.parseNumberWithOptionalMinus()
+			if err != nil {
+				return labels, err
+			}
+			label.To = to
+			label.IsRange = true
+		}
+		labels = append(labels, label)
+		if !p.curTokenIs(token.COMMA) {
+			return labels, nil
+		}
+		p.nextToken() // Eat ','
 	}
-	return &commands
+}
+
+// atCaseLabel reports whether the current token starts the labels of a CASE
+// arm.
+func (p *Parser) atCaseLabel() bool {
+	return p.curTokenIs(token.NUM) || p.curTokenIs(token.MINUS)
 }
 
 func (p *Parser) parseProcedures() []*ast.Procedure {
 	procedures := []*ast.Procedure{}
-	// parse commands until we hit one of the stopTokens (ELSE, ENDIF) or EOF
-	for p.curToken.Type != token.PROGRAM {
+	for p.curToken.Type != token.PROGRAM && p.curToken.Type != token.EOF {
 		procedure, err := p.parseProcedure()
 		if err != nil {
-			p.errors = append(p.errors, fmt.Sprintf("failed to parse procedure: %v", err))
-			continue
+			p.report(err)
+			// Skip what is left of the broken procedure.
+			for !p.curTokenIs(token.PROCEDURE) && !p.curTokenIs(token.FUNCTION) && !p.curTokenIs(token.PROGRAM) && !p.curTokenIs(token.EOF) {
+				p.nextToken()
+			}
+		}
+		if procedure != nil {
+			procedures = append(procedures, procedure)
 		}
-		procedures = append(procedures, procedure)
 	}
 	return procedures
 }
 
 func (p *Parser) parseProcedure() (*ast.Procedure, error) {
-	proc := ast.Procedure{}
-	if !p.curTokenIs(token.PROCEDURE) {
-		return nil, fmt.Errorf("line %d: expected PROCEDURE got %s", p.curToken.Line, p.curToken.Type)
+	proc := &ast.Procedure{}
+	if !p.curTokenIs(token.PROCEDURE) && !p.curTokenIs(token.FUNCTION) {
+		return nil, p.expected("PROCEDURE or FUNCTION")
 	}
 	proc.Token = p.curToken
+	proc.Returns = p.curTokenIs(token.FUNCTION)
 	p.nextToken()
+	if p.curTokenIs(token.RECURSIVE) {
+		proc.Recursive = true
+		p.nextToken()
+	}
 	procHead, err := p.parseProcHead()
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse prochead: %v", err)
+	proc.ProcHead = *procHead
+	if err != nil && !p.recoverTo(err, token.IS) {
+		return proc, errReported
 	}
-	if !p.curTokenIs(token.IS) {
-		return nil, fmt.Errorf("line %d: expected IS got %s", p.curToken.Line, p.curToken.Type)
+	if proc.Returns {
+		p.expect(token.RETURNS, "RETURNS")
 	}
-	p.nextToken()
-	if !p.curTokenIs(token.BEGIN) {
-		declarations := p.parseDeclarations()
-		proc.Declarations = *declarations
+	if err := p.parseContracts(&proc.ProcHead); err != nil && !p.recoverTo(err, token.IS) {
+		return proc, errReported
 	}
-	p.nextToken() // eat 'BEGIN'
-	commands := p.parseCommandsUntil(token.END)
+	p.expect(token.IS, "IS")
+	proc.Constants = p.parseConstants()
+	proc.Declarations = p.parseDeclarations()
+	proc.Commands = p.parseBody()
+	if !p.curTokenIs(token.END) {
+		return proc, p.expected("END")
+	}
+	proc.EndToken = p.curToken
 	p.nextToken()
-	proc.Commands = *commands
-	proc.ProcHead = *procHead
-	return &proc, nil
+	return proc, nil
 }
 
+// parseProcHead always returns the head, filled in as far as it could be
+// parsed.
 func (p *Parser) parseProcHead() (*ast.ProcHead, error) {
-	// fmt.Printf("in parseProcHead\n")
-	procHead := ast.ProcHead{}
+	procHead := &ast.ProcHead{}
 	procHead.Token = p.curToken
-	name := p.parsePidentifier()
+	if !p.curTokenIs(token.PIDENTIFIER) {
+		return procHead, p.expected("procedure name")
+	}
+	procHead.Name = p.parsePidentifier()
 	if !p.curTokenIs(token.LPAREN) {
-		return nil, fmt.Errorf("line %d expected '(' got %s", p.curToken.Line, p.curToken.Type)
+		return procHead, p.expected("'('")
 	}
 
 	p.nextToken()
 	argsDecl, err := p.parseArgsDecl()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse args: %s", err)
+		return procHead, err
 	}
+	procHead.ArgsDecl = *argsDecl
 	if !p.curTokenIs(token.RPAREN) {
-		return nil, fmt.Errorf("line %d expected ')' got %s", p.curToken.Line, p.curToken.Type)
+		return procHead, p.expected("')'")
 	}
+	procHead.EndToken = p.curToken
 	p.nextToken()
-	procHead.ArgsDecl = *argsDecl
-	procHead.Name = name
-	return &procHead, nil
+	return procHead, nil
 }
 
 func (p *Parser) parseArgsDecl() (*[]ast.ArgDecl, error) {
@@ -508,54 +951,187 @@ func (p *Parser) parseArgsDecl() (*[]ast.ArgDecl, error) {
 	if p.curTokenIs(token.RPAREN) {
 		return &args, nil
 	}
-	if !p.curTokenIs(token.PIDENTIFIER) && !p.curTokenIs(token.T) {
-		return nil, fmt.Errorf("failed parsing argsdecl line %d: expected pidentifier or T in args, got %s", p.curToken.Line, p.curToken.Type)
+	if !p.atArgDecl() {
+		return nil, p.expected("identifier or T in parameters")
 	}
 	arg, err := p.parseArgDecl()
 	if err != nil {
-		return nil, fmt.Errorf("failed parsing argsdecl: %v", err)
+		return nil, err
 	}
 	args = append(args, *arg)
 	for p.curTokenIs(token.COMMA) {
 		p.nextToken() // eat ','
-		if !p.curTokenIs(token.PIDENTIFIER) && !p.curTokenIs(token.T) {
-			return nil, fmt.Errorf("failed parsing argsdecl line %d: expected pidentifier in args, got %s", p.curToken.Line, p.curToken.Type)
+		if !p.atArgDecl() {
+			return nil, p.expected("identifier or T in parameters")
 		}
 		arg, err := p.parseArgDecl()
 		if err != nil {
-			return nil, fmt.Errorf("failed parsing argsdecl: %v", err)
+			return nil, err
 		}
 		args = append(args, *arg)
 	}
 	return &args, nil
 }
 
+// atArgDecl reports whether the current token can start a parameter.
+func (p *Parser) atArgDecl() bool {
+	switch p.curToken.Type {
+	case token.PIDENTIFIER, token.T, token.IN, token.OUT, token.INOUT:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) parseArgDecl() (*ast.ArgDecl, error) {
 
 	var arg ast.ArgDecl
+	arg.Token = p.curToken
+	if p.curTokenIs(token.IN) || p.curTokenIs(token.OUT) || p.curTokenIs(token.INOUT) {
+		arg.Mode = p.curToken
+		p.nextToken()
+	}
 	if p.curTokenIs(token.T) {
 		arg.IsTable = true
 		p.nextToken()
 	}
-	arg.Token = p.curToken
+	if !p.curTokenIs(token.PIDENTIFIER) {
+		return nil, p.expected("parameter name")
+	}
 	name := p.parsePidentifier()
 	arg.Name = name
+	if arg.IsTable && p.curTokenIs(token.LBRACKET) {
+		p.nextToken() // eat '['
+		dims, endToken, err := p.parseBounds()
+		if err != nil {
+			return nil, err
+		}
+		arg.Dims = dims
+		arg.EndToken = endToken
+	}
 	return &arg, nil
 }
 
-func (p *Parser) parseCommandsUntil(stopTokens ...token.TokenType) *[]ast.Command {
+// parseBlock parses commands up to the token that ends the enclosing block.
+// A command that fails to parse is reported, skipped and kept as an
+// ast.BadCommand, or as the partial node if the construct got far enough.
+func (p *Parser) parseBlock() []ast.Command {
+	return p.parseBlockUntil(func() bool { return false })
+}
+
+// parseBlockUntil is parseBlock that also stops where stop reports true.
+func (p *Parser) parseBlockUntil(stop func() bool) []ast.Command {
 	commands := []ast.Command{}
-	// parse commands until we hit one of the stopTokens (ELSE, ENDIF) or EOF
-	for !p.inSet(p.curToken.Type, stopTokens) && p.curToken.Type != token.EOF {
-		// fmt.Printf("parsing until: %v\n", stopTokens)
+	for !p.atBlockEnd() && !stop() {
+		start := p.curToken
 		command, err := p.ParseCommand()
 		if err != nil {
-			p.errors = append(p.errors, fmt.Sprintf("failed to parse command: %v", err))
-			// maybe break or continue
+			p.report(err)
+			bad := p.synchronize(start)
+			if command == nil {
+				command = bad
+			}
 		}
 		commands = append(commands, command)
 	}
-	return &commands
+	return commands
+}
+
+// parseBody parses the commands of a procedure or of the main program up to
+// END. Terminators that do not close anything are reported and skipped.
+func (p *Parser) parseBody() []ast.Command {
+	commands := p.parseBlock()
+	for p.atBlockEnd() && !p.atSectionEnd() {
+		p.report(p.errorf("unexpected %s", describe(p.curToken)))
+		p.nextToken()
+		commands = append(commands, p.parseBlock()...)
+	}
+	return commands
+}
+
+// atBlockEnd reports whether the current token ends a block of commands.
+func (p *Parser) atBlockEnd() bool {
+	switch p.curToken.Type {
+	case token.ELSE, token.ENDIF, token.ENDWHILE, token.ENDFOR, token.UNTIL, token.ENDCASE, token.ENDASM:
+		return true
+	}
+	return p.atSectionEnd()
+}
+
+// atSectionEnd reports whether the current token ends a procedure or the
+// main program, or starts the next one.
+func (p *Parser) atSectionEnd() bool {
+	switch p.curToken.Type {
+	case token.END, token.PROCEDURE, token.FUNCTION, token.PROGRAM, token.EOF:
+		return true
+	}
+	return false
+}
+
+// synchronize implements panic-mode recovery. It skips the rest of a command
+// that failed to parse and returns an error node covering it. Blocks opened
+// inside the skipped tokens are skipped whole. Skipping stops after a ';' and
+// before any other token that ends a block, so parsing resumes at a command
+// boundary of the enclosing construct.
+func (p *Parser) synchronize(start token.Token) *ast.BadCommand {
+	bad := &ast.BadCommand{Token: start, EndToken: start}
+	depth := 0
+	for !p.atSectionEnd() {
+		switch p.curToken.Type {
+		case token.IF, token.WHILE, token.FOR, token.REPEAT, token.CASE, token.ASM:
+			depth++
+		case token.ELSE:
+			if depth == 0 {
+				return bad
+			}
+		case token.ENDIF, token.ENDWHILE, token.ENDFOR, token.UNTIL, token.ENDCASE, token.ENDASM:
+			if depth == 0 {
+				return bad
+			}
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				bad.EndToken = p.curToken
+				p.nextToken()
+				return bad
+			}
+		}
+		bad.EndToken = p.curToken
+		p.nextToken()
+	}
+	return bad
+}
+
+// skipTo advances to a token of type want, stopping early at ';' or at a
+// token that ends a block. It reports whether want was found.
+func (p *Parser) skipTo(want token.TokenType) bool {
+	for !p.curTokenIs(want) && !p.curTokenIs(token.SEMICOLON) && !p.atBlockEnd() {
+		p.nextToken()
+	}
+	return p.curTokenIs(want)
+}
+
+// recoverTo reports err and skips to want, so that the block after a broken
+// header is still parsed.
+func (p *Parser) recoverTo(err error, want token.TokenType) bool {
+	p.report(err)
+	return p.skipTo(want)
+}
+
+// expect consumes a token of type t. A missing token is reported and parsing
+// goes on as if it had been there.
+func (p *Parser) expect(t token.TokenType, what string) {
+	if !p.curTokenIs(t) {
+		p.report(p.missing(t, what))
+		return
+	}
+	p.nextToken()
+}
+
+// badCondition returns a placeholder for a condition that failed to parse at
+// the current token.
+func (p *Parser) badCondition() *ast.Condition {
+	bad := &ast.BadExpression{Token: p.curToken}
+	return &ast.Condition{Left: bad, Operator: token.Token{Type: token.ILLEGAL}, Right: bad}
 }
 func (p *Parser) inSet(tt token.TokenType, set []token.TokenType) bool {
 	for _, t := range set {
@@ -577,26 +1153,31 @@ func (p *Parser) parseIdentifier() (*ast.Identifier, error) {
 
 		index, err := p.parseIndex() // Parse the index as an expression
 		if err != nil {
-			return nil, fmt.Errorf("in parseIdentifier(): failed to parse index: %v", err)
+			return nil, err
 		}
-		identifier.Index = index.String()
+		identifier.Index = index
+		identifier.Indices = []ast.Value{index}
 		identifier.IsTable = true
+		for p.curTokenIs(token.COMMA) {
+			p.nextToken() // eat ','
+			index, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			identifier.Indices = append(identifier.Indices, index)
+		}
 		if !p.curTokenIs(token.RBRACKET) { // RBRACKET = ]
-			return nil, fmt.Errorf("parseIdentifier: expected a ']' , got %s", p.peekToken.Type)
+			return nil, p.expected("']'")
 		}
+		identifier.EndToken = p.curToken
 		p.nextToken()
 		// fmt.Println("token at the end: %v", p.curToken)
 	}
 	return identifier, nil
 }
 
-func (p *Parser) parseIndex() (ast.Expression, error) {
-	// fmt.Printf("in parseIndex: %v\n", p.curToken)
-	val, err := p.parseValue()
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse index: %v", err)
-	}
-	return val, nil
+func (p *Parser) parseIndex() (ast.Value, error) {
+	return p.parseExpression(LOWEST)
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -616,39 +1197,104 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
+// parseMathExpression parses the right-hand side of an assignment. The
+// outermost operation is kept in the MathExpression itself; nested ones are
+// BinaryExpression operands.
 func (p *Parser) parseMathExpression() (*ast.MathExpression, error) {
-	// fmt.Printf("in parseMathExpression: %v\n", p.curToken)
-	left, err := p.parseValue()
+	expr, err := p.parseExpression(LOWEST)
 	if err != nil {
-		return nil, fmt.Errorf("in parseMathExpression: failed to parse left value: %v", err) // Error handling - failed to parse left-hand value
+		return nil, err
 	}
-	if !isOperator(p.curToken.Type) {
+	if be, ok := expr.(*ast.BinaryExpression); ok {
 		return &ast.MathExpression{
-			Left:     left,
-			Operator: token.Token{Type: token.ILLEGAL, Literal: ""},
-			Right:    nil, // no right operand
+			Left:     be.Left,
+			Operator: be.Operator,
+			Right:    be.Right,
 		}, nil
 	}
-	operator := p.curToken
-	p.nextToken() // eat operator
-	right, err := p.parseValue()
+	return &ast.MathExpression{
+		Left:     expr,
+		Operator: token.Token{Type: token.ILLEGAL, Literal: ""},
+		Right:    nil, // no right operand
+	}, nil
+}
+
+// Binding powers of the expression operators, from loosest to tightest.
+const (
+	_ int = iota
+	LOWEST
+	SUM     // + -
+	PRODUCT // * / %
+	PREFIX  // -x
+)
+
+var precedences = map[token.TokenType]int{
+	token.PLUS:   SUM,
+	token.MINUS:  SUM,
+	token.MULT:   PRODUCT,
+	token.DIVIDE: PRODUCT,
+	token.MODULO: PRODUCT,
+}
+
+func (p *Parser) curPrecedence() int {
+	if prec, ok := precedences[p.curToken.Type]; ok {
+		return prec
+	}
+	return LOWEST
+}
+
+// parseExpression is a Pratt parser for arithmetic expressions. It parses
+// operators that bind tighter than precedence and leaves curToken on the
+// first token after the expression.
+func (p *Parser) parseExpression(precedence int) (ast.Value, error) {
+	left, err := p.parsePrefix()
 	if err != nil {
-		return nil, fmt.Errorf("in parseMathExpression: failed to parse right value: %v", err) // Error handling - failed to parse right-hand value
+		return nil, err
 	}
-	me := &ast.MathExpression{
-		Left:     left,
-		Operator: operator,
-		Right:    right,
+	for precedence < p.curPrecedence() {
+		operator := p.curToken
+		p.nextToken() // eat operator
+		right, err := p.parseExpression(precedences[operator.Type])
+		if err != nil {
+			return nil, err
+		}
+		left = &ast.BinaryExpression{
+			Token:    operator,
+			Left:     left,
+			Operator: operator,
+			Right:    right,
+		}
 	}
-	return me, nil
+	return left, nil
 }
 
-func isOperator(tt token.TokenType) bool {
-	switch tt {
-	case token.PLUS, token.MINUS, token.DIVIDE, token.MULT, token.MODULO:
-		return true
+func (p *Parser) parsePrefix() (ast.Value, error) {
+	switch p.curToken.Type {
+	case token.MINUS:
+		operator := p.curToken
+		p.nextToken()
+		right, err := p.parseExpression(PREFIX)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.UnaryExpression{
+			Token:    operator,
+			Operator: operator,
+			Right:    right,
+		}, nil
+	case token.LPAREN:
+		p.nextToken() // eat '('
+		expr, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if !p.curTokenIs(token.RPAREN) {
+			return nil, p.expected("')'")
+		}
+		p.nextToken() // eat ')'
+		return expr, nil
 	}
-	return false
+	return p.parseValue()
 }
 
 func isConditionOperator(tt token.TokenType) bool {
@@ -659,21 +1305,100 @@ func isConditionOperator(tt token.TokenType) bool {
 	return false
 }
 
-func (p *Parser) parseCondition() (*ast.Condition, error) {
-	// fmt.Printf("in parseCondition: %v\n", p.curToken)
-	left, err := p.parseValue()
+// parseCondition parses a boolean expression. OR binds loosest, then AND,
+// then NOT; parentheses group conditions.
+func (p *Parser) parseCondition() (ast.BoolExpression, error) {
+	left, err := p.parseAnd()
 	if err != nil {
-		return nil, fmt.Errorf("in parseCondition: failed to parse left value: %v", err) // Error handling - failed to parse left-hand value
+		return nil, err
+	}
+	for p.curTokenIs(token.OR) {
+		operator := p.curToken
+		p.nextToken() // eat OR
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &ast.LogicalExpression{Token: operator, Left: left, Operator: operator, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseAnd() (ast.BoolExpression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.curTokenIs(token.AND) {
+		operator := p.curToken
+		p.nextToken() // eat AND
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &ast.LogicalExpression{Token: operator, Left: left, Operator: operator, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseNot() (ast.BoolExpression, error) {
+	if p.curTokenIs(token.NOT) {
+		not := p.curToken
+		p.nextToken() // eat NOT
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.NotExpression{Token: not, Operand: operand}, nil
+	}
+	if p.curTokenIs(token.LPAREN) {
+		// The parenthesis may open a nested condition or just the arithmetic
+		// left operand of a comparison. Try the condition first and back
+		// off if it does not end at the matching ')'.
+		saved := p.save()
+		p.nextToken() // eat '('
+		if cond, err := p.parseCondition(); err == nil && p.curTokenIs(token.RPAREN) {
+			p.nextToken() // eat ')'
+			return cond, nil
+		}
+		p.restore(saved)
+	}
+	return p.parseComparison()
+}
+
+// parserState is a snapshot of the parser used to backtrack.
+type parserState struct {
+	lexer     lexer.Lexer
+	curToken  token.Token
+	peekToken token.Token
+	errors    int
+}
+
+func (p *Parser) save() parserState {
+	return parserState{lexer: *p.l, curToken: p.curToken, peekToken: p.peekToken, errors: len(p.errors)}
+}
+
+func (p *Parser) restore(s parserState) {
+	*p.l = s.lexer
+	p.curToken = s.curToken
+	p.peekToken = s.peekToken
+	p.errors = p.errors[:s.errors]
+}
+
+func (p *Parser) parseComparison() (*ast.Condition, error) {
+	left, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
 	}
 	if !isConditionOperator(p.curToken.Type) {
-		return nil, fmt.Errorf("parseCondition: expected a comparison operator, got %s", p.peekToken.Type)
+		return nil, p.expected("comparison operator")
 	}
 	operator := p.curToken
 	// fmt.Printf("%v - THIS IS THE OPERATOR I GOT\n\n\n", operator)
 	p.nextToken() // eat operator
-	right, err := p.parseValue()
+	right, err := p.parseExpression(LOWEST)
 	if err != nil {
-		return nil, fmt.Errorf("in parseCondition: failed to parse right value: %v", err) // Error handling - failed to parse right-hand value
+		return nil, err
 	}
 	return &ast.Condition{
 		Left:     left,
@@ -705,7 +1430,24 @@ func (p *Parser) parseValue() (ast.Value, error) {
 		p.nextToken()
 		return val, nil
 	case token.PIDENTIFIER:
+		if p.peekTokenIs(token.LPAREN) {
+			return p.parseFunctionCall()
+		}
 		return p.parseIdentifier()
 	}
-	return nil, fmt.Errorf("failed to parse value for token: %v", p.curToken)
+	return nil, p.expected("number or identifier")
+}
+
+func (p *Parser) parseFunctionCall() (ast.Value, error) {
+	call := &ast.FunctionCall{Token: p.curToken}
+	call.Name = p.parsePidentifier()
+	p.nextToken() // eat '('
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	call.Args = args
+	call.EndToken = p.curToken
+	p.nextToken() // eat ')'
+	return call, nil
 }
@@ -0,0 +1,47 @@
+// Package prelude holds the runtime library that is linked into every
+// program. It is parsed once, separately from user code, so user line
+// numbers are not shifted and its names stay out of the user's namespace.
+package prelude
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/lexer"
+	"github.com/Meduza3/imp/parser"
+)
+
+// File is the name the prelude is reported under in diagnostics.
+const File = "<prelude>"
+
+// Prefix marks the names reserved for the prelude.
+const Prefix = "built_in_"
+
+//go:embed prelude.imp
+var source string
+
+var (
+	once    sync.Once
+	program *ast.Program
+)
+
+// Program returns the parsed prelude. It panics if the embedded source does
+// not parse, since that is a bug in the compiler rather than in user code.
+func Program() *ast.Program {
+	once.Do(func() {
+		p := parser.New(lexer.NewFile(File, source))
+		program = p.ParseFragment()
+		if errs := p.Errors(); len(errs) != 0 {
+			panic(fmt.Sprintf("prelude: %s", strings.Join(errs.Strings(), "; ")))
+		}
+	})
+	return program
+}
+
+// IsReserved reports whether name belongs to the prelude.
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, Prefix)
+}
@@ -7,8 +7,13 @@ import (
 	"os"
 
 	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/callgraph"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/include"
 	"github.com/Meduza3/imp/lexer"
+	"github.com/Meduza3/imp/lint"
 	"github.com/Meduza3/imp/parser"
+	"github.com/Meduza3/imp/sema"
 	"github.com/Meduza3/imp/tac"
 	"github.com/Meduza3/imp/token"
 	"github.com/Meduza3/imp/translator"
@@ -52,7 +57,7 @@ func StartParsing(in io.Reader, out io.Writer) {
 		io.WriteString(out, fmt.Sprintf("%#+v", program))
 		io.WriteString(out, "\n")
 		for _, err := range p.Errors() {
-			io.WriteString(out, err)
+			io.WriteString(out, err.String())
 			fmt.Println()
 		}
 	}
@@ -75,8 +80,8 @@ func StartParsingFile(filepath string, out io.Writer) {
 	}
 
 	// Pass the entire content to the parser
-	l := lexer.New(string(content))
-	l2 := lexer.New(string(content))
+	l := lexer.NewFile(filepath, string(content))
+	l2 := lexer.NewFile(filepath, string(content))
 	for tok := l2.NextToken(); tok.Type != token.EOF; tok = l2.NextToken() {
 		fmt.Println(tok)
 	}
@@ -118,7 +123,7 @@ func StartGeneratingFile(filepath string, out io.Writer) {
 	}
 
 	// Pass the entire content to the parser
-	l := lexer.New(string(content))
+	l := lexer.NewFile(filepath, string(content))
 	p := parser.New(l)
 	program := p.ParseProgram()
 
@@ -171,32 +176,53 @@ func StartGeneratingFile(filepath string, out io.Writer) {
 	}
 }
 
-func StartFile(filepath string, out io.Writer) {
-	file, err := os.Open(filepath)
-	if err != nil {
-		fmt.Fprintf(out, "Error opening file %s: %v\n", filepath, err)
-		return
-	}
-	defer file.Close()
+// Options configure how StartFile compiles a program.
+type Options struct {
+	// SearchPaths are searched for included files after the directory of
+	// the including file.
+	SearchPaths []string
+	// Release strips ASSERT, REQUIRES and ENSURES checks.
+	Release bool
+	// BoundsCheck checks every access to an element of an array at run
+	// time, through T parameters too.
+	BoundsCheck bool
+	// Warnings turns warnings off or makes them errors.
+	Warnings lint.Config
+	// CallGraph, when "dot" or "json", writes the call graph of the
+	// program in that format to standard output instead of compiling it.
+	CallGraph string
+}
 
-	// Read the entire file content
-	content, err := io.ReadAll(file)
-	// fmt.Println("# reading file")
+// StartFile compiles the program in filepath.
+func StartFile(filepath string, out io.Writer, opts Options) {
+	loader := include.NewLoader(opts.SearchPaths...)
+	// fmt.Print("# parsing program...		")
+	program, err := loader.Load(filepath)
 	if err != nil {
 		fmt.Fprintf(out, "Error reading file %s: %v\n", filepath, err)
 		return
 	}
-
-	l := lexer.New(string(content))
-	p := parser.New(l)
-	// fmt.Print("# parsing program...		")
-	program := p.ParseProgram()
 	// fmt.Println("# parsed. ")
-	for _, err := range p.Errors() {
-		fmt.Printf("# parse Error: %s\n", err)
+	sources := loader.Sources
+	if errs := loader.Errors(); errs.HasErrors() {
+		report(errs, sources)
+		return
+	}
+	if opts.CallGraph != "" {
+		writeCallGraph(callgraph.Build(program), opts.CallGraph)
+		return
+	}
+	warnings := opts.Warnings.Apply(sema.Check(program))
+	if !warnings.HasErrors() {
+		warnings = append(warnings, lint.Run(program, &opts.Warnings)...)
+	}
+	report(warnings, sources)
+	if warnings.HasErrors() {
 		return
 	}
 	g := tac.NewGenerator()
+	g.CheckContracts = !opts.Release
+	g.BoundsCheck = opts.BoundsCheck
 	// fmt.Printf("# generating TAC...		")
 	g.Generate(program)
 	// fmt.Println("# generated. ")
@@ -214,21 +240,41 @@ func StartFile(filepath string, out io.Writer) {
 	// }
 	g.Instructions = tac.MergeLabelOnlyInstructions(g.Instructions)
 
-	for _, err := range g.Errors {
-		fmt.Printf("# Generator Error: %s\n", err)
+	if g.Errors.HasErrors() {
+		report(g.Errors, sources)
 		return
 	}
 	translator := translator.New(*g.SymbolTable)
+	translator.BoundsCheck = opts.BoundsCheck
 	// fmt.Println("# Translating TAC...		")
 	translator.Translate(g.Instructions)
-	for _, err := range translator.Errors() {
-		fmt.Printf("# ERROR: %s\n", err)
+	if errs := translator.Errors(); errs.HasErrors() {
+		report(errs, sources)
 		return
 	}
-	if len(translator.Errors()) == 0 {
-		for _, instr := range translator.Output {
-			fmt.Fprintf(out, "%s\n", instr.String())
-		}
+	for _, instr := range translator.Output {
+		fmt.Fprintf(out, "%s\n", instr.String())
 	}
 	translator.St.Display(os.Stdout, "")
 }
+
+// report prints the diagnostics of a phase in source order.
+func report(errs diag.List, sources diag.Sources) {
+	errs.Sort()
+	diag.Fprint(os.Stdout, errs, sources)
+}
+
+func writeCallGraph(g *callgraph.Graph, format string) {
+	var err error
+	switch format {
+	case "dot":
+		err = g.WriteDOT(os.Stdout)
+	case "json":
+		err = g.WriteJSON(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown call graph format %q, want dot or json", format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,265 @@
+package sema
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/code"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/symboltable"
+)
+
+func (c *checker) checkCommands(commands []ast.Command) {
+	for _, cmd := range commands {
+		c.checkCommand(cmd)
+	}
+}
+
+func (c *checker) checkCommand(cmd ast.Command) {
+	switch node := cmd.(type) {
+	case *ast.AssignCommand:
+		c.checkValue(node.MathExpression.Left)
+		if node.MathExpression.Right != nil {
+			c.checkValue(node.MathExpression.Right)
+		}
+		c.checkTarget(&node.Identifier)
+
+	case *ast.ReadCommand:
+		c.checkTarget(&node.Identifier)
+
+	case *ast.WriteCommand:
+		if node.Value != nil {
+			c.checkValue(node.Value)
+		}
+
+	case *ast.IfCommand:
+		c.checkCondition(node.Condition)
+		branch := c.block
+		c.follow(branch)
+		c.checkCommands(node.ThenCommands)
+		then := c.block
+		c.follow(branch)
+		c.checkCommands(node.ElseCommands)
+		c.follow(then, c.block)
+
+	case *ast.WhileCommand:
+		// A constant condition takes one of the edges out of the test
+		// only, as lint assumes when it looks for unreachable code.
+		value, constant := ast.ConstantCondition(node.Condition)
+		head := c.follow(c.block)
+		c.checkCondition(node.Condition)
+		after := &block{}
+		if !constant || !value {
+			link(head, after)
+		}
+		c.startBlock(&block{})
+		if !constant || value {
+			link(head, c.block)
+		}
+		c.checkLoopBody(node.Commands, after, head)
+		link(c.block, head)
+		c.startBlock(after)
+
+	case *ast.RepeatCommand:
+		value, constant := ast.ConstantCondition(node.Condition)
+		body := c.follow(c.block)
+		test, after := &block{}, &block{}
+		c.checkLoopBody(node.Commands, after, test)
+		link(c.block, test)
+		c.startBlock(test)
+		c.checkCondition(node.Condition)
+		if !constant || !value {
+			link(test, body)
+		}
+		if !constant || value {
+			link(test, after)
+		}
+		c.startBlock(after)
+
+	case *ast.ForCommand:
+		c.checkValue(node.From)
+		c.checkValue(node.To)
+		if !c.checkReserved(&node.Iterator) {
+			return
+		}
+		it := &object{name: node.Iterator.Value, kind: iterator}
+		it.lo, it.hi, it.ranged = c.loopRange(node)
+		_, constFrom := c.fold(node.From)
+		_, constTo := c.fold(node.To)
+		it.exact = it.ranged && constFrom && constTo
+		c.scope = &scope{objects: map[string]*object{it.name: it}, outer: c.scope}
+		// The body may run no times at all.
+		head := c.follow(c.block)
+		after := &block{}
+		link(head, after)
+		c.follow(head)
+		c.checkLoopBody(node.Commands, after, head)
+		link(c.block, head)
+		c.startBlock(after)
+		c.scope = c.scope.outer
+
+	case *ast.ProcCallCommand:
+		proc := c.checkCall(&node.Name, node.Args, node)
+		if proc != nil && proc.returns {
+			c.errorf(diag.ErrCallKind, &node.Name, "function %s called as a procedure", proc.name)
+		}
+
+	case *ast.ReturnCommand:
+		if c.current == nil || !c.current.returns {
+			c.errorf(diag.ErrReturn, node, "RETURN outside of a function")
+		}
+		c.checkValue(node.Value)
+		c.jump(c.flow.exit)
+
+	case *ast.BreakCommand:
+		if len(c.loops) == 0 {
+			c.errorf(diag.ErrLoopControl, node, "BREAK outside of a loop")
+			return
+		}
+		c.jump(c.loops[len(c.loops)-1].breakTo)
+
+	case *ast.ContinueCommand:
+		if len(c.loops) == 0 {
+			c.errorf(diag.ErrLoopControl, node, "CONTINUE outside of a loop")
+			return
+		}
+		c.jump(c.loops[len(c.loops)-1].continueTo)
+
+	case *ast.CaseCommand:
+		c.checkValue(node.Value)
+		c.checkCaseLabels(node)
+		branch := c.block
+		var ends []*block
+		for _, arm := range node.Arms {
+			c.follow(branch)
+			c.checkCommands(arm.Commands)
+			ends = append(ends, c.block)
+		}
+		// Without an ELSE, a value no arm matches goes straight to the end.
+		c.follow(branch)
+		c.checkCommands(node.ElseCommands)
+		c.follow(append(ends, c.block)...)
+
+	case *ast.AsmCommand:
+		c.checkAsm(node)
+
+	case *ast.AssertCommand:
+		c.checkCondition(node.Condition)
+	}
+}
+
+// checkLoopBody checks the body of a loop whose BREAK jumps to breakTo and
+// whose CONTINUE jumps to continueTo.
+func (c *checker) checkLoopBody(commands []ast.Command, breakTo, continueTo *block) {
+	c.loops = append(c.loops, loop{breakTo: breakTo, continueTo: continueTo})
+	c.checkCommands(commands)
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+func (c *checker) checkCondition(cond ast.BoolExpression) {
+	switch cond := cond.(type) {
+	case *ast.Condition:
+		c.checkValue(cond.Left)
+		c.checkValue(cond.Right)
+	case *ast.LogicalExpression:
+		c.checkCondition(cond.Left)
+		c.checkCondition(cond.Right)
+	case *ast.NotExpression:
+		c.checkCondition(cond.Operand)
+	}
+}
+
+// checkTarget checks a variable or array element being assigned.
+func (c *checker) checkTarget(id *ast.Identifier) {
+	o := c.checkAccess(id)
+	if o == nil {
+		return
+	}
+	c.checkModifiable(o, id)
+	if id.Index == nil {
+		c.assign(o)
+	}
+}
+
+// checkModifiable rejects writing to a constant, a FOR iterator or an IN
+// parameter.
+func (c *checker) checkModifiable(o *object, at ast.Node) {
+	switch {
+	case o.kind == constant:
+		c.errorf(diag.ErrConstant, at, "cannot modify constant %s", o.name)
+	case o.kind == iterator:
+		c.errorf(diag.ErrIteratorModified, at, "cannot modify FOR iterator %s", o.name)
+	case o.kind == param && o.mode == symboltable.In:
+		c.errorf(diag.ErrParamMode, at, "cannot modify IN parameter %s", o.name)
+	}
+}
+
+// checkCaseLabels rejects an empty range among the labels of a CASE and a
+// label that covers a value an earlier one already does.
+func (c *checker) checkCaseLabels(node *ast.CaseCommand) {
+	type labelRange struct {
+		lo, hi int
+		label  *ast.CaseLabel
+	}
+	var ranges []labelRange
+	for i := range node.Arms {
+		for j := range node.Arms[i].Labels {
+			label := &node.Arms[i].Labels[j]
+			lo, _ := strconv.Atoi(label.From.Value)
+			hi, _ := strconv.Atoi(label.To.Value)
+			if lo > hi {
+				c.errorf(diag.ErrCaseLabel, label, "empty CASE range %s", label)
+				continue
+			}
+			ranges = append(ranges, labelRange{lo: lo, hi: hi, label: label})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].lo < ranges[j].lo })
+	// widest is the range reaching highest among those before the one
+	// being compared.
+	for i, widest := 1, 0; i < len(ranges); i++ {
+		if ranges[i].lo <= ranges[widest].hi {
+			c.errorf(diag.ErrCaseLabel, ranges[i].label, "CASE label %s overlaps %s", ranges[i].label, ranges[widest].label)
+		}
+		if ranges[i].hi > ranges[widest].hi {
+			widest = i
+		}
+	}
+}
+
+// checkAsm checks the labels of an ASM block and resolves the variables
+// named in it. Only STORE and GET write a variable directly. Jumps within
+// the block are not followed: its instructions are taken to run in order.
+func (c *checker) checkAsm(node *ast.AsmCommand) {
+	labels := map[string]bool{}
+	for _, ins := range node.Instructions {
+		for i := range ins.Labels {
+			label := &ins.Labels[i]
+			if labels[label.Value] {
+				c.errorf(diag.ErrAsm, label, "label %s defined twice in ASM block", label.Value)
+			}
+			labels[label.Value] = true
+		}
+	}
+	for _, ins := range node.Instructions {
+		operand, ok := ins.Operand.(*ast.Pidentifier)
+		if !ok {
+			continue
+		}
+		if code.IsJump(ins.Opcode.Literal) {
+			if !labels[operand.Value] {
+				c.errorf(diag.ErrAsm, operand, "undefined label %s in ASM block", operand.Value)
+			}
+			continue
+		}
+		o := c.resolve(operand.Value, operand)
+		if o == nil {
+			continue
+		}
+		if ins.Opcode.Literal == code.STORE || ins.Opcode.Literal == code.GET {
+			c.checkModifiable(o, operand)
+			c.assign(o)
+		}
+	}
+}
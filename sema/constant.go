@@ -0,0 +1,86 @@
+package sema
+
+import (
+	"strconv"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/token"
+)
+
+// eval computes a constant expression: a CONST value or an array bound.
+// Division and modulo round towards minus infinity and give 0 for a zero
+// divisor, as at run time.
+func (c *checker) eval(v ast.Value) (int, bool) {
+	return c.constant(v, true)
+}
+
+// fold computes an expression when it is constant and reports nothing when
+// it is not.
+func (c *checker) fold(v ast.Value) (int, bool) {
+	return c.constant(v, false)
+}
+
+func (c *checker) constant(v ast.Value, report bool) (int, bool) {
+	switch val := v.(type) {
+	case *ast.NumberLiteral:
+		n, err := strconv.Atoi(val.Value)
+		return n, err == nil
+	case *ast.UnaryExpression:
+		right, ok := c.constant(val.Right, report)
+		return -right, ok
+	case *ast.Identifier:
+		if val.Index != nil {
+			break
+		}
+		o := c.scope.lookup(val.Value)
+		if o == nil {
+			if report {
+				c.errorf(diag.ErrUndeclared, val, "undeclared constant %s", val.Value)
+			}
+			return 0, false
+		}
+		if o.kind != constant {
+			break
+		}
+		return o.value, true
+	case *ast.BinaryExpression:
+		left, okLeft := c.constant(val.Left, report)
+		right, okRight := c.constant(val.Right, report)
+		if !okLeft || !okRight {
+			return 0, false
+		}
+		switch val.Operator.Type {
+		case token.PLUS:
+			return left + right, true
+		case token.MINUS:
+			return left - right, true
+		case token.MULT:
+			return left * right, true
+		case token.DIVIDE:
+			if right == 0 {
+				return 0, true
+			}
+			q := left / right
+			if left%right != 0 && (left < 0) != (right < 0) {
+				q--
+			}
+			return q, true
+		case token.MODULO:
+			if right == 0 {
+				return 0, true
+			}
+			m := left % right
+			if m != 0 && (m < 0) != (right < 0) {
+				m += right
+			}
+			return m, true
+		}
+	case *ast.BadExpression:
+		return 0, false
+	}
+	if report {
+		c.errorf(diag.ErrConstant, v, "%s is not a constant expression", v)
+	}
+	return 0, false
+}
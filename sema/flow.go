@@ -0,0 +1,222 @@
+package sema
+
+import (
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/symboltable"
+)
+
+// The checker builds a control-flow graph of each procedure and of main while
+// it walks their commands, recording in it every read and assignment of a
+// variable. A data-flow analysis over the graph then finds the variables that
+// are read before they are assigned on every path (an error) or only on some
+// of them (a warning).
+
+// event is a read or an assignment of a tracked variable.
+type event struct {
+	o      *object
+	assign bool
+	at     ast.Node // of a read
+}
+
+// block is a basic block: events that happen one after another, with no
+// jump in between.
+type block struct {
+	events []event
+	preds  []*block
+}
+
+func link(from, to *block) {
+	to.preds = append(to.preds, from)
+}
+
+// graph is the control-flow graph of a procedure or of main. Blocks are kept
+// in source order, which is the order reads are reported in.
+type graph struct {
+	entry, exit *block
+	blocks      []*block
+	// slots numbers the tracked variables: the local variables and the OUT
+	// parameters, which hold no value when the procedure starts.
+	slots map[*object]int
+}
+
+// loop is where BREAK and CONTINUE leave the innermost loop for.
+type loop struct {
+	breakTo, continueTo *block
+}
+
+// beginFlow starts the graph of a procedure or of main.
+func (c *checker) beginFlow() {
+	entry := &block{}
+	c.flow = &graph{entry: entry, exit: &block{}, blocks: []*block{entry}, slots: map[*object]int{}}
+	c.block = entry
+	c.loops = nil
+}
+
+// endFlow falls through to the exit of the graph, which becomes the current
+// block so ENSURES conditions are read there, and keeps the graph for
+// checkFlow.
+func (c *checker) endFlow() {
+	link(c.block, c.flow.exit)
+	c.startBlock(c.flow.exit)
+	c.flows = append(c.flows, c.flow)
+}
+
+// track checks the initialisation of o when it is a variable or an OUT
+// parameter, which hold no value until something is assigned to them.
+func (c *checker) track(o *object) {
+	if o.kind == variable || o.kind == param && o.mode == symboltable.Out {
+		c.flow.slots[o] = len(c.flow.slots)
+	}
+}
+
+func (c *checker) startBlock(b *block) {
+	c.flow.blocks = append(c.flow.blocks, b)
+	c.block = b
+}
+
+// follow starts a new block reached from each of preds.
+func (c *checker) follow(preds ...*block) *block {
+	b := &block{}
+	for _, p := range preds {
+		link(p, b)
+	}
+	c.startBlock(b)
+	return b
+}
+
+// jump leaves the current block for to. The commands after a jump are only
+// reached through a label of their own, so they start a block without
+// predecessors.
+func (c *checker) jump(to *block) {
+	link(c.block, to)
+	c.follow()
+}
+
+func (c *checker) read(o *object, at ast.Node) {
+	if _, ok := c.flow.slots[o]; ok {
+		c.block.events = append(c.block.events, event{o: o, at: at})
+	}
+}
+
+func (c *checker) assign(o *object) {
+	if _, ok := c.flow.slots[o]; ok {
+		c.block.events = append(c.block.events, event{o: o, assign: true})
+	}
+}
+
+// facts hold, for each tracked variable, whether it is assigned on every
+// path to a point of the program and whether it is on at least one.
+type facts struct {
+	must, may []bool
+}
+
+func (f facts) assign(slot int) {
+	f.must[slot] = true
+	f.may[slot] = true
+}
+
+func (f facts) equal(g facts) bool {
+	for i := range f.must {
+		if f.must[i] != g.must[i] || f.may[i] != g.may[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFlow reports the reads of tracked variables that are not preceded by
+// an assignment on every path from the start of g, each variable once.
+// Unreachable code is not checked.
+func (c *checker) checkFlow(g *graph) {
+	reached := g.reachable()
+	out := map[*block]facts{}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range g.blocks {
+			if !reached[b] {
+				continue
+			}
+			f := g.enter(b, out, reached)
+			for _, e := range b.events {
+				if e.assign {
+					f.assign(g.slots[e.o])
+				}
+			}
+			if old, ok := out[b]; !ok || !f.equal(old) {
+				out[b] = f
+				changed = true
+			}
+		}
+	}
+
+	reported := map[*object]bool{}
+	for _, b := range g.blocks {
+		if !reached[b] {
+			continue
+		}
+		f := g.enter(b, out, reached)
+		for _, e := range b.events {
+			slot := g.slots[e.o]
+			switch {
+			case e.assign:
+				f.assign(slot)
+			case f.must[slot] || reported[e.o]:
+			case f.may[slot]:
+				c.errors.Warnf(diag.WarnMaybeUninitialized, diag.SpanOf(e.at), "%s may be used uninitialized", e.o.name)
+				reported[e.o] = true
+			default:
+				c.errorf(diag.ErrUninitialized, e.at, "use of uninitialized variable %s", e.o.name)
+				reported[e.o] = true
+			}
+		}
+	}
+}
+
+// enter computes the facts at the start of b from those at the end of its
+// reached predecessors. A predecessor not computed yet is left out: it
+// would only add assignments on every path and take none away.
+func (g *graph) enter(b *block, out map[*block]facts, reached map[*block]bool) facts {
+	n := len(g.slots)
+	f := facts{must: make([]bool, n), may: make([]bool, n)}
+	if b == g.entry {
+		return f
+	}
+	for i := range f.must {
+		f.must[i] = true
+	}
+	for _, p := range b.preds {
+		pf, ok := out[p]
+		if !reached[p] || !ok {
+			continue
+		}
+		for i := range f.must {
+			f.must[i] = f.must[i] && pf.must[i]
+			f.may[i] = f.may[i] || pf.may[i]
+		}
+	}
+	return f
+}
+
+// reachable returns the blocks reached from the entry of the graph.
+func (g *graph) reachable() map[*block]bool {
+	succs := map[*block][]*block{}
+	for _, b := range g.blocks {
+		for _, p := range b.preds {
+			succs[p] = append(succs[p], b)
+		}
+	}
+	reached := map[*block]bool{g.entry: true}
+	work := []*block{g.entry}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, s := range succs[b] {
+			if !reached[s] {
+				reached[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	return reached
+}
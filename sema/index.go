@@ -0,0 +1,101 @@
+package sema
+
+import (
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/symboltable"
+	"github.com/Meduza3/imp/token"
+)
+
+// checkIndex rejects an index of the array o that is known at compile time
+// to fall outside dim: a constant, or a FOR iterator with constant bounds
+// plus or minus a constant. Other expressions of iterators only have their
+// range estimated, and may never take the values outside dim, so they are
+// warned about instead.
+func (c *checker) checkIndex(o *object, dim symboltable.Dimension, index ast.Value) {
+	lo, hi, ok := c.indexRange(index)
+	if !ok || lo >= dim.From && hi <= dim.To {
+		return
+	}
+	if !c.exactRange(index) {
+		c.errors.Warnf(diag.WarnIndexRange, diag.SpanOf(index), "index %s may run from %d to %d, outside the bounds %d:%d of array %s", index, lo, hi, dim.From, dim.To, o.name)
+		return
+	}
+	if lo == hi {
+		c.errorf(diag.ErrIndexRange, index, "index %d is outside the bounds %d:%d of array %s", lo, dim.From, dim.To, o.name)
+		return
+	}
+	c.errorf(diag.ErrIndexRange, index, "index %s runs from %d to %d, outside the bounds %d:%d of array %s", index, lo, hi, dim.From, dim.To, o.name)
+}
+
+// indexRange returns the lowest and highest value v takes: a constant, a
+// FOR iterator with bounds known at compile time, or sums and differences
+// of them. Operands are taken to vary independently, so the range of an
+// expression of several iterators may be wider than the values it takes.
+func (c *checker) indexRange(v ast.Value) (lo, hi int, ok bool) {
+	if n, ok := c.fold(v); ok {
+		return n, n, true
+	}
+	switch v := v.(type) {
+	case *ast.Identifier:
+		if o := c.scope.lookup(v.Value); v.Index == nil && o != nil && o.kind == iterator && o.ranged {
+			return o.lo, o.hi, true
+		}
+	case *ast.UnaryExpression:
+		lo, hi, ok := c.indexRange(v.Right)
+		return -hi, -lo, ok
+	case *ast.BinaryExpression:
+		leftLo, leftHi, okLeft := c.indexRange(v.Left)
+		rightLo, rightHi, okRight := c.indexRange(v.Right)
+		if !okLeft || !okRight {
+			break
+		}
+		switch v.Operator.Type {
+		case token.PLUS:
+			return leftLo + rightLo, leftHi + rightHi, true
+		case token.MINUS:
+			return leftLo - rightHi, leftHi - rightLo, true
+		}
+	}
+	return 0, 0, false
+}
+
+// exactRange reports whether v takes every value of the range indexRange
+// returns for it: a constant, or an iterator of a loop with constant bounds
+// offset by a constant.
+func (c *checker) exactRange(v ast.Value) bool {
+	if _, ok := c.fold(v); ok {
+		return true
+	}
+	switch v := v.(type) {
+	case *ast.Identifier:
+		o := c.scope.lookup(v.Value)
+		return v.Index == nil && o != nil && o.kind == iterator && o.exact
+	case *ast.UnaryExpression:
+		return c.exactRange(v.Right)
+	case *ast.BinaryExpression:
+		_, constLeft := c.fold(v.Left)
+		_, constRight := c.fold(v.Right)
+		switch v.Operator.Type {
+		case token.PLUS, token.MINUS:
+			return constLeft && c.exactRange(v.Right) || constRight && c.exactRange(v.Left)
+		}
+	}
+	return false
+}
+
+// loopRange returns the values the iterator of a FOR loop takes, when its
+// bounds are known at compile time and the body runs at all.
+func (c *checker) loopRange(node *ast.ForCommand) (lo, hi int, ok bool) {
+	fromLo, fromHi, okFrom := c.indexRange(node.From)
+	toLo, toHi, okTo := c.indexRange(node.To)
+	if !okFrom || !okTo {
+		return 0, 0, false
+	}
+	if node.IsDownTo {
+		lo, hi = toLo, fromHi
+	} else {
+		lo, hi = fromLo, toHi
+	}
+	return lo, hi, lo <= hi
+}
@@ -0,0 +1,317 @@
+// Package sema checks that a program is well formed before any code is
+// generated for it. It resolves every name against the scopes of the
+// language and reports undeclared and redeclared names, misused arrays,
+// calls that do not match the called procedure, writes to constants, FOR
+// iterators and IN parameters, CASE labels that overlap, labels missing from
+// ASM blocks, and reads of variables that may not have been assigned yet.
+// Code generation relies on these checks and does not repeat them.
+//
+// Names are resolved in the scope of the procedure, or of main, they are
+// used in. Procedures see only their parameters, constants and local
+// variables; a FOR iterator is visible in the body of its loop. A procedure
+// can call the procedures declared before it, and itself when RECURSIVE.
+package sema
+
+import (
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/callgraph"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/prelude"
+	"github.com/Meduza3/imp/symboltable"
+	"github.com/Meduza3/imp/token"
+)
+
+type kind int
+
+const (
+	variable kind = iota
+	array
+	constant
+	iterator
+	param      // scalar parameter
+	arrayParam // T parameter
+)
+
+// object is what a name in a scope stands for.
+type object struct {
+	name string
+	kind kind
+	mode symboltable.ParamMode // of a param
+	// dims are the dimensions of an array, and of a T parameter declared
+	// with bounds. A T parameter without them has one dimension of
+	// unknown size. bounded is set for an array, or a T parameter with
+	// several dimensions, whose bounds could be computed and are in order,
+	// so its indices can be checked.
+	dims    []symboltable.Dimension
+	bounded bool
+	value   int // of a constant
+	// lo and hi are the first and last value of an iterator whose loop has
+	// bounds known at compile time, when ranged is set. They are exact
+	// when those bounds are constants, and otherwise only include every
+	// value the iterator takes.
+	lo, hi        int
+	ranged, exact bool
+}
+
+// dimensions is the number of indices an element of the array takes.
+func (o *object) dimensions() int {
+	return max(len(o.dims), 1)
+}
+
+// scope holds the names declared in a procedure or in main, or the
+// iterator of a FOR loop, which hides a name of the same spelling.
+type scope struct {
+	objects map[string]*object
+	outer   *scope
+}
+
+func (s *scope) lookup(name string) *object {
+	for ; s != nil; s = s.outer {
+		if o, ok := s.objects[name]; ok {
+			return o
+		}
+	}
+	return nil
+}
+
+// procedure is a declared PROCEDURE or FUNCTION.
+type procedure struct {
+	name      string
+	at        token.Position // of the name in the heading
+	recursive bool
+	returns   bool
+	params    []*object
+}
+
+// kind names the procedure in messages.
+func (p *procedure) kind() string {
+	if p.returns {
+		return "function"
+	}
+	return "procedure"
+}
+
+type checker struct {
+	errors     diag.List
+	procedures map[string]*procedure // declared so far
+	calls      *callgraph.Graph
+	current    *procedure // being checked, nil in main
+	scope      *scope
+	loops      []loop   // enclosing loops, innermost last
+	flow       *graph   // of the procedure or main being checked
+	flows      []*graph // of those checked so far
+	block      *block   // current block of flow
+}
+
+// Check reports the semantic errors and warnings of a parsed program with
+// its includes merged. Code can be generated for a program without errors.
+func Check(program *ast.Program) diag.List {
+	c := &checker{procedures: map[string]*procedure{}, calls: callgraph.Build(program)}
+	for _, proc := range program.Procedures {
+		if proc != nil {
+			c.checkProcedure(proc)
+		}
+	}
+	if program.Main != nil {
+		c.checkMain(program.Main)
+	}
+	// A name that does not resolve may be what assigns a variable, so
+	// initialisation is only checked in a program free of other errors.
+	if !c.errors.HasErrors() {
+		for _, g := range c.flows {
+			c.checkFlow(g)
+		}
+	}
+	return c.errors
+}
+
+func (c *checker) errorf(code string, at ast.Node, format string, args ...interface{}) {
+	c.errors.Errorf(code, diag.SpanOf(at), format, args...)
+}
+
+// checkReserved rejects user declarations that would clash with the prelude.
+func (c *checker) checkReserved(name *ast.Pidentifier) bool {
+	if prelude.IsReserved(name.Value) {
+		c.errorf(diag.ErrReserved, name, "name %s is reserved for built-in procedures", name.Value)
+		return false
+	}
+	return true
+}
+
+func (c *checker) checkProcedure(node *ast.Procedure) {
+	head := &node.ProcHead
+	name := head.Name.Value
+	c.scope = &scope{objects: map[string]*object{}}
+	c.current = nil
+	if !c.checkReserved(&head.Name) {
+		return
+	}
+	proc := &procedure{name: name, at: head.Name.Pos(), recursive: node.Recursive, returns: node.Returns}
+	if _, ok := c.procedures[name]; ok {
+		c.errorf(diag.ErrRedeclared, &head.Name, "procedure %s is already declared", name)
+	} else {
+		c.procedures[name] = proc
+	}
+	c.current = proc
+	c.beginFlow()
+	if node.Returns && !hasReturn(node.Commands) {
+		c.errorf(diag.ErrReturn, &head.Name, "function %s has no RETURN", name)
+	}
+
+	c.declareConstants(node.Constants)
+	for i := range head.ArgsDecl {
+		proc.params = append(proc.params, c.declareParam(&head.ArgsDecl[i]))
+	}
+	c.declareVariables(node.Declarations)
+	for _, contract := range head.Requires {
+		c.checkCondition(contract.Condition)
+	}
+	c.checkCommands(node.Commands)
+	c.endFlow()
+	for _, contract := range head.Ensures {
+		c.checkCondition(contract.Condition)
+	}
+	c.current = nil
+}
+
+func (c *checker) checkMain(node *ast.Main) {
+	c.scope = &scope{objects: map[string]*object{}}
+	c.current = nil
+	c.beginFlow()
+	c.declareConstants(node.Constants)
+	c.declareVariables(node.Declarations)
+	c.checkCommands(node.Commands)
+	c.endFlow()
+}
+
+// declare adds o to the innermost scope under name.
+func (c *checker) declare(name *ast.Pidentifier, o *object) bool {
+	if !c.checkReserved(name) {
+		return false
+	}
+	if _, ok := c.scope.objects[name.Value]; ok {
+		if c.current != nil {
+			c.errorf(diag.ErrRedeclared, name, "%s is already declared in procedure %s", name.Value, c.current.name)
+		} else {
+			c.errorf(diag.ErrRedeclared, name, "%s is already declared", name.Value)
+		}
+		return false
+	}
+	o.name = name.Value
+	c.scope.objects[name.Value] = o
+	return true
+}
+
+func (c *checker) declareConstants(constants []ast.ConstDeclaration) {
+	for i := range constants {
+		decl := &constants[i]
+		// A constant that cannot be computed is still declared, so its
+		// uses are not reported as well.
+		value, _ := c.eval(decl.Value)
+		c.declare(&decl.Name, &object{kind: constant, value: value})
+	}
+}
+
+// declareParam declares a parameter of the procedure being checked. The
+// object is returned even when the name clashes, so calls still see every
+// parameter.
+func (c *checker) declareParam(decl *ast.ArgDecl) *object {
+	o := &object{kind: param, name: decl.Name.Value}
+	switch decl.Mode.Type {
+	case token.IN:
+		o.mode = symboltable.In
+	case token.OUT:
+		o.mode = symboltable.Out
+	case token.INOUT:
+		o.mode = symboltable.InOut
+	}
+	if decl.IsTable {
+		o.kind = arrayParam
+		if o.mode != symboltable.ByReference {
+			c.errorf(diag.ErrParamMode, decl, "array parameter %s cannot have mode %s", decl.Name.Value, decl.Mode.Literal)
+			o.mode = symboltable.ByReference
+		}
+		if len(decl.Dims) > 1 {
+			o.dims, o.bounded = c.dimensions(&decl.Name, decl.Dims)
+		}
+	}
+	if !c.checkReserved(&decl.Name) {
+		return o
+	}
+	if _, ok := c.scope.objects[o.name]; ok {
+		c.errorf(diag.ErrRedeclared, &decl.Name, "parameter %s is already declared in procedure %s", o.name, c.current.name)
+		return o
+	}
+	c.scope.objects[o.name] = o
+	c.track(o)
+	return o
+}
+
+func (c *checker) declareVariables(decls []ast.Declaration) {
+	for i := range decls {
+		decl := &decls[i]
+		o := &object{kind: variable}
+		if decl.IsTable {
+			o.kind = array
+			o.dims, o.bounded = c.dimensions(&decl.Pidentifier, append([]ast.Bounds{{From: decl.From, To: decl.To}}, decl.Inner...))
+		}
+		if c.declare(&decl.Pidentifier, o) {
+			c.track(o)
+		}
+	}
+}
+
+// dimensions evaluates the bounds of the array name and rejects those whose
+// lower bound is above the upper one.
+func (c *checker) dimensions(name *ast.Pidentifier, bounds []ast.Bounds) ([]symboltable.Dimension, bool) {
+	dims := make([]symboltable.Dimension, len(bounds))
+	ok := true
+	for i, b := range bounds {
+		from, okFrom := c.eval(b.From)
+		to, okTo := c.eval(b.To)
+		if okFrom && okTo && from > to {
+			c.errorf(diag.ErrIndexRange, b.From, "array %s has lower bound %d above upper bound %d", name.Value, from, to)
+			okTo = false
+		}
+		ok = ok && okFrom && okTo
+		dims[i] = symboltable.Dimension{From: from, To: to}
+	}
+	return dims, ok
+}
+
+// resolve finds the object a name stands for.
+func (c *checker) resolve(name string, at ast.Node) *object {
+	o := c.scope.lookup(name)
+	if o == nil || prelude.IsReserved(name) {
+		c.errorf(diag.ErrUndeclared, at, "undeclared variable %s", name)
+		return nil
+	}
+	return o
+}
+
+// hasReturn reports whether any of commands, or a command nested in them, is
+// a RETURN.
+func hasReturn(commands []ast.Command) bool {
+	found := false
+	for _, cmd := range commands {
+		ast.Walk(cmd, func(n ast.Node) {
+			if _, ok := n.(*ast.ReturnCommand); ok {
+				found = true
+			}
+		})
+	}
+	return found
+}
+
+// modeName spells a parameter mode as it is written.
+func modeName(mode symboltable.ParamMode) string {
+	switch mode {
+	case symboltable.In:
+		return "IN"
+	case symboltable.Out:
+		return "OUT"
+	case symboltable.InOut:
+		return "INOUT"
+	}
+	return ""
+}
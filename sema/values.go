@@ -0,0 +1,160 @@
+package sema
+
+import (
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/prelude"
+	"github.com/Meduza3/imp/symboltable"
+)
+
+func (c *checker) checkValue(v ast.Value) {
+	switch val := v.(type) {
+	case *ast.UnaryExpression:
+		c.checkValue(val.Right)
+	case *ast.BinaryExpression:
+		c.checkValue(val.Left)
+		c.checkValue(val.Right)
+	case *ast.FunctionCall:
+		proc := c.checkCall(&val.Name, val.Args, val)
+		if proc != nil && !proc.returns {
+			c.errorf(diag.ErrCallKind, &val.Name, "procedure %s does not return a value", proc.name)
+		}
+	case *ast.Identifier:
+		if o := c.checkAccess(val); o != nil && val.Index == nil {
+			c.read(o, val)
+		}
+	}
+}
+
+// checkAccess resolves a variable or array element and checks that it is
+// indexed as declared. It returns nil for an undeclared name.
+func (c *checker) checkAccess(id *ast.Identifier) *object {
+	for _, index := range id.Indices {
+		c.checkValue(index)
+	}
+	o := c.resolve(id.Value, id)
+	if o == nil {
+		return nil
+	}
+	isArray := o.kind == array || o.kind == arrayParam
+	switch {
+	case isArray && id.Index == nil:
+		c.errorf(diag.ErrArrayMisuse, id, "array %s used without an index", id.Value)
+	case !isArray && id.Index != nil:
+		c.errorf(diag.ErrArrayMisuse, id, "%s is not an array", id.Value)
+	case isArray && len(id.Indices) != o.dimensions():
+		c.errorf(diag.ErrArrayMisuse, id, "array %s has %d dimensions, got %d indices", id.Value, o.dimensions(), len(id.Indices))
+	case o.bounded:
+		for k, index := range id.Indices {
+			c.checkIndex(o, o.dims[k], index)
+		}
+	}
+	return o
+}
+
+// checkCall checks a call of a procedure or function and its arguments. It
+// returns the called procedure, or nil when there is none to call.
+func (c *checker) checkCall(name *ast.Pidentifier, args []ast.Value, at ast.Node) *procedure {
+	proc, ok := c.procedures[name.Value]
+	if !ok || prelude.IsReserved(name.Value) {
+		d := diag.Errorf(diag.ErrUndefinedProc, diag.SpanOf(name), "undefined procedure %s", name.Value)
+		if later := c.calls.Lookup(name.Value); later != nil && later.Proc != nil && !prelude.IsReserved(name.Value) {
+			d.WithNote("%s is declared later, at %s; a procedure can only call those declared before it", name.Value, later.Proc.ProcHead.Name.Pos())
+		}
+		c.errors.Add(d)
+		c.checkArguments(args)
+		return nil
+	}
+	if proc == c.current && !proc.recursive {
+		c.errors.Add(diag.Errorf(diag.ErrRecursiveCall, diag.SpanOf(name), "%s %s calls itself but is not declared RECURSIVE", proc.kind(), proc.name).
+			WithFix("declare it RECURSIVE to allow recursion", diag.At(proc.at), "RECURSIVE "))
+	}
+	if len(args) != len(proc.params) {
+		c.errorf(diag.ErrArgumentCount, at, "%s %s takes %d arguments, got %d", proc.kind(), proc.name, len(proc.params), len(args))
+		c.checkArguments(args)
+		return proc
+	}
+	for i, arg := range args {
+		c.checkArgument(arg, proc.params[i], proc)
+	}
+	return proc
+}
+
+// checkArguments resolves the arguments of a call that cannot be matched
+// against parameters. Plain variables are not read, as the call may be
+// meant to assign them.
+func (c *checker) checkArguments(args []ast.Value) {
+	for _, arg := range args {
+		if id, ok := arg.(*ast.Identifier); ok && id.Index == nil {
+			c.resolve(id.Value, id)
+			continue
+		}
+		c.checkValue(arg)
+	}
+}
+
+// checkArgument checks arg passed for the parameter p of proc. An IN
+// parameter reads the argument and an INOUT one also writes it. Any other
+// scalar parameter is taken to assign a variable passed for it.
+func (c *checker) checkArgument(arg ast.Value, p *object, proc *procedure) {
+	id, isVar := arg.(*ast.Identifier)
+	isVar = isVar && id.Index == nil
+	if !isVar {
+		switch {
+		case p.kind == arrayParam:
+			c.errorf(diag.ErrArgumentKind, arg, "%s %s expects an array for parameter %s, got %s", proc.kind(), proc.name, p.name, arg)
+		case p.mode == symboltable.Out || p.mode == symboltable.InOut:
+			c.errorf(diag.ErrParamMode, arg, "%s parameter %s of %s %s needs a variable, got %s", modeName(p.mode), p.name, proc.kind(), proc.name, arg)
+		}
+		c.checkValue(arg)
+		return
+	}
+	o := c.resolve(id.Value, id)
+	if o == nil {
+		return
+	}
+	isArray := o.kind == array || o.kind == arrayParam
+	switch {
+	case p.kind == arrayParam && !isArray:
+		c.errorf(diag.ErrArgumentKind, arg, "%s %s expects an array for parameter %s, got %s", proc.kind(), proc.name, p.name, arg)
+	case p.kind == arrayParam:
+		if !sameShape(p, o) {
+			c.errorf(diag.ErrArgumentKind, arg, "array %s does not have the shape of parameter %s of %s %s", o.name, p.name, proc.kind(), proc.name)
+		}
+	case isArray:
+		c.errorf(diag.ErrArgumentKind, arg, "%s %s expects a variable for parameter %s, got array %s", proc.kind(), proc.name, p.name, o.name)
+	case p.mode == symboltable.In:
+		c.read(o, id)
+	case p.mode == symboltable.InOut:
+		c.checkModifiable(o, id)
+		c.read(o, id)
+	case p.mode == symboltable.Out:
+		c.checkModifiable(o, id)
+		c.assign(o)
+	default:
+		// The procedure may assign the variable. Constants and IN
+		// parameters are passed as copies.
+		c.assign(o)
+	}
+}
+
+// sameShape reports whether an array can be passed for a T parameter: both
+// have the same number of dimensions and, since the flattened index depends
+// on them, the same sizes after the first. Bounds may differ: the parameter
+// sees the elements of the argument in order from the first. A parameter of
+// several dimensions is checked against its own bounds, so its first
+// dimension must also fit in the argument's.
+func sameShape(p, arg *object) bool {
+	if p.dimensions() != arg.dimensions() {
+		return false
+	}
+	for k := 1; k < len(p.dims); k++ {
+		if p.dims[k].Size() != arg.dims[k].Size() {
+			return false
+		}
+	}
+	if p.bounded && arg.bounded && p.dims[0].Size() > arg.dims[0].Size() {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+// Package stdlib holds the standard library of IMP procedures shipped with
+// the compiler. Unlike the prelude it is not linked in by default: a program
+// includes the files it needs, as in INCLUDE "std/math.imp";.
+package stdlib
+
+import (
+	"embed"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Prefix starts the INCLUDE paths that name library files.
+const Prefix = "std/"
+
+// FilePrefix starts the names library files are reported under in
+// diagnostics, so they cannot be mistaken for files on disk.
+const FilePrefix = "<std>/"
+
+//go:embed *.imp
+var files embed.FS
+
+// Lookup returns the name of the library file an INCLUDE path refers to.
+func Lookup(path string) (file string, ok bool) {
+	name, ok := strings.CutPrefix(path, Prefix)
+	if !ok {
+		return "", false
+	}
+	if _, err := fs.Stat(files, name); err != nil {
+		return "", false
+	}
+	return FilePrefix + name, true
+}
+
+// Source returns the contents of a library file named as Lookup returns it.
+func Source(file string) (string, bool) {
+	name, ok := strings.CutPrefix(file, FilePrefix)
+	if !ok {
+		return "", false
+	}
+	content, err := files.ReadFile(name)
+	if err != nil {
+		return "", false
+	}
+	return string(content), true
+}
+
+// Names lists the INCLUDE paths of all library files.
+func Names() []string {
+	entries, _ := fs.Glob(files, "*.imp")
+	sort.Strings(entries)
+	for i, name := range entries {
+		entries[i] = Prefix + name
+	}
+	return entries
+}
@@ -3,6 +3,19 @@ package symboltable
 import (
 	"fmt"
 	"io"
+	"strconv"
+)
+
+// Scopes other than those of procedures are named in upper case, so they
+// cannot clash with a procedure, whose name is lower case.
+const (
+	// Global is the scope of the cells shared by the whole program: literal
+	// constants and the operands of the built-in procedures. Every other
+	// scope sees it, but neither main nor a procedure sees the names of
+	// another.
+	Global = "GLOBAL"
+	// Main is the scope of the main program, and the label of its code.
+	Main = "MAIN"
 )
 
 type SymbolTable struct {
@@ -34,10 +47,10 @@ func (st *SymbolTable) Initialize(sym *Symbol, currentProc string) {
 			return
 		}
 	}
-	// If it isn’t found in the current procedure’s table, try the main table.
-	if mainTable, ok := st.Table["main"]; ok {
-		if _, exists := mainTable[sym.Name]; exists {
-			mainTable[sym.Name] = sym
+	// If it isn’t found in the current procedure’s table, try the global one.
+	if globalTable, ok := st.Table[Global]; ok {
+		if _, exists := globalTable[sym.Name]; exists {
+			globalTable[sym.Name] = sym
 		}
 	}
 }
@@ -50,10 +63,34 @@ const (
 	PROCEDURE   SymbolKind = "PROCEDURE"
 	CONSTANT    SymbolKind = "CONSTANT"
 	RETURNADDR  SymbolKind = "RETURNADDR"
+	RESULT      SymbolKind = "RESULT"
 	ITERATOR    SymbolKind = "ITERATOR"
 	TEMP        SymbolKind = "TEMP"
 )
 
+// ParamMode is how a scalar parameter is passed. Every parameter gets the
+// address of its argument, but the modes differ in what the caller must
+// pass and what the procedure may do with it.
+type ParamMode int
+
+const (
+	ByReference ParamMode = iota // no mode given: read and written freely
+	In                           // a copy of any value; not assigned by the procedure
+	Out                          // a variable the procedure assigns before reading it
+	InOut                        // an initialised variable the procedure may assign
+)
+
+// Dimension is the index range of one dimension of an array. A
+// multi-dimensional array is stored flattened in row-major order, so From
+// and To of its Symbol bound the flattened index, which counts from 0.
+type Dimension struct {
+	From int
+	To   int
+}
+
+// Size is the number of indices in the dimension.
+func (d Dimension) Size() int { return d.To - d.From + 1 }
+
 type Symbol struct {
 	Name          string
 	IsInitialized bool
@@ -66,13 +103,29 @@ type Symbol struct {
 	Arguments     []*Symbol
 	ArgumentsType []SymbolKind
 	ArgumentIndex int
+	Mode          ParamMode   // mode of an ARGUMENT
+	Value         int         // value of a CONSTANT
+	Dims          []Dimension // every dimension of a multi-dimensional array
+	// Bounds of a T parameter of one dimension, when indices are checked:
+	// the parameters the caller passes the From and To of its array in.
+	Bounds []*Symbol
+
+	ArgCount  int
+	Recursive bool // procedure may call itself; calls save its frame on the stack
+	Returns   bool // function leaving its value in <name>_result
+}
 
-	ArgCount int
+// IsLiteral reports whether a CONSTANT stands for a number written in the
+// program rather than for a named CONST.
+func (s *Symbol) IsLiteral() bool {
+	_, err := strconv.Atoi(s.Name)
+	return s.Kind == CONSTANT && err == nil
 }
 
 func New() *SymbolTable {
 	pt := make(map[string]map[string]*Symbol)
-	pt["main"] = make(map[string]*Symbol)
+	pt[Main] = make(map[string]*Symbol)
+	pt[Global] = make(map[string]*Symbol)
 	return &SymbolTable{
 		Table:         pt,
 		CurrentOffset: 100,
@@ -108,25 +161,14 @@ func (st *SymbolTable) Declare(name, procedureName string, symbol Symbol) (*Symb
 	return st.Table[procedureName][name], nil
 }
 
-// Lookup searches for a symbol in the main table and specific procedure table (if given).
+// Lookup searches for a symbol in the scope of a procedure, or of main,
+// and then in the global scope.
 func (st *SymbolTable) Lookup(name, procedureName string) (*Symbol, error) {
-	// If a procedure name is provided, try to look up in that procedure's symbol table.
-	if procedureName != "" {
-		if procedureSymbols, ok := st.Table[procedureName]; ok {
-			if symbol, ok := procedureSymbols[name]; ok {
-				return symbol, nil
-			}
-		}
-	}
-
-	// Always try the main table as a fallback.
-	if symbol, ok := st.Table["main"][name]; ok {
+	if symbol, ok := st.Table[procedureName][name]; ok {
 		return symbol, nil
 	}
-
-	// Symbol was not found in either place.
-	if procedureName != "" {
-		return nil, fmt.Errorf("symbol %q not found in procedure %q or in main", name, procedureName)
+	if symbol, ok := st.Table[Global][name]; ok {
+		return symbol, nil
 	}
-	return nil, fmt.Errorf("symbol %q not found in main table", name)
+	return nil, fmt.Errorf("symbol %q not found in procedure %q", name, procedureName)
 }
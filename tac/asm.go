@@ -0,0 +1,57 @@
+package tac
+
+import (
+	"strconv"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/code"
+)
+
+// generateAsm emits one OpAsm per machine instruction of an ASM block.
+// Labels are local to the block: each is renamed to a fresh generator label
+// so blocks cannot clash with each other or with generated code.
+func (g *Generator) generateAsm(node *ast.AsmCommand) error {
+	labels := map[string]string{}
+	for _, ins := range node.Instructions {
+		for i := range ins.Labels {
+			label := &ins.Labels[i]
+			if _, ok := labels[label.Value]; ok {
+				return g.errorf(label, "label %s defined twice in ASM block", label.Value)
+			}
+			labels[label.Value] = g.newLabel() + "_" + label.Value
+		}
+	}
+
+	for i := range node.Instructions {
+		ins := &node.Instructions[i]
+		var names []string
+		for _, label := range ins.Labels {
+			names = append(names, labels[label.Value])
+		}
+		if ins.Opcode.Type == "" {
+			g.emit(Instruction{Labels: names})
+			continue
+		}
+		out := Instruction{Op: OpAsm, Opcode: ins.Opcode.Literal, Labels: names}
+		switch operand := ins.Operand.(type) {
+		case *ast.Pidentifier:
+			if code.IsJump(out.Opcode) {
+				target, ok := labels[operand.Value]
+				if !ok {
+					return g.errorf(operand, "undefined label %s in ASM block", operand.Value)
+				}
+				out.JumpTo = target
+				break
+			}
+			sym, err := g.lookup(operand.Value, operand)
+			if err != nil {
+				return err
+			}
+			out.Arg1 = sym
+		case *ast.NumberLiteral:
+			out.Operand, _ = strconv.Atoi(operand.Value)
+		}
+		g.emit(out)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package tac
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/symboltable"
+)
+
+// Costs of dispatching a CASE in the VM, used to pick its lowering. A
+// comparison is LOAD and SUB (10 each) plus up to two conditional jumps
+// (1 each). A jump table checks both bounds, then computes the entry with
+// SET (50), ADD, STORE and RTRN (10 each) and takes the JUMP found there.
+const (
+	costCompare   = 22
+	costGoto      = 1
+	costJumpTable = 2*costCompare + 50 + 10 + 10 + 10 + costGoto
+
+	// maxJumpTable bounds the number of entries, one instruction each.
+	maxJumpTable = 256
+)
+
+// caseRange is a label of a CASE arm with the label of the arm's code.
+type caseRange struct {
+	lo, hi int
+	target string
+	label  *ast.CaseLabel
+}
+
+func (g *Generator) generateCase(node *ast.CaseCommand) error {
+	selector, err := g.generateValue(node.Value)
+	if err != nil {
+		return err
+	}
+	labelEnd := g.newLabel()
+	labelDefault := labelEnd
+	if len(node.ElseCommands) > 0 {
+		labelDefault = g.newLabel()
+	}
+
+	var ranges []caseRange
+	armLabels := make([]string, len(node.Arms))
+	for i := range node.Arms {
+		armLabels[i] = g.newLabel()
+		for j := range node.Arms[i].Labels {
+			label := &node.Arms[i].Labels[j]
+			lo, _ := strconv.Atoi(label.From.Value)
+			hi, _ := strconv.Atoi(label.To.Value)
+			ranges = append(ranges, caseRange{lo: lo, hi: hi, target: armLabels[i], label: label})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].lo < ranges[j].lo })
+
+	switch {
+	case len(ranges) == 0:
+		g.emit(Instruction{Op: OpGoto, JumpTo: labelDefault})
+	case useJumpTable(ranges):
+		g.generateJumpTable(&selector, ranges, labelDefault)
+	default:
+		g.generateCaseTree(&selector, ranges, false, labelDefault)
+	}
+
+	for i, arm := range node.Arms {
+		g.emit(Instruction{Labels: []string{armLabels[i]}})
+		for _, cmd := range arm.Commands {
+			if err := g.Generate(cmd); err != nil {
+				g.report(err)
+			}
+		}
+		g.emit(Instruction{Op: OpGoto, JumpTo: labelEnd})
+	}
+	if len(node.ElseCommands) > 0 {
+		g.emit(Instruction{Labels: []string{labelDefault}})
+		for _, cmd := range node.ElseCommands {
+			if err := g.Generate(cmd); err != nil {
+				g.report(err)
+			}
+		}
+	}
+	g.emit(Instruction{Labels: []string{labelEnd}})
+	return nil
+}
+
+// useJumpTable reports whether a jump table dispatches over the sorted
+// ranges more cheaply than a comparison tree in the worst case.
+func useJumpTable(ranges []caseRange) bool {
+	span := ranges[len(ranges)-1].hi - ranges[0].lo + 1
+	if span > maxJumpTable {
+		return false
+	}
+	return costJumpTable < caseTreeCost(ranges, false)
+}
+
+// caseTreeCost is the cost of the longest path through the comparison tree
+// generateCaseTree emits for ranges.
+func caseTreeCost(ranges []caseRange, aboveLo bool) int {
+	if len(ranges) == 1 {
+		r := ranges[0]
+		if r.lo == r.hi || aboveLo {
+			return costCompare + costGoto
+		}
+		return 2*costCompare + costGoto
+	}
+	mid := len(ranges) / 2
+	return costCompare + max(caseTreeCost(ranges[:mid], aboveLo), caseTreeCost(ranges[mid:], true))
+}
+
+// generateCaseTree binary searches the sorted ranges for selector. aboveLo
+// tells that selector is already known not to be below the first range.
+func (g *Generator) generateCaseTree(selector *symboltable.Symbol, ranges []caseRange, aboveLo bool, labelDefault string) {
+	if len(ranges) == 1 {
+		r := ranges[0]
+		switch {
+		case r.lo == r.hi && !aboveLo:
+			g.emit(Instruction{Op: OpIfEQ, Arg1: selector, Arg2: g.constant(strconv.Itoa(r.lo)), JumpTo: r.target})
+		case aboveLo:
+			g.emit(Instruction{Op: OpIfLE, Arg1: selector, Arg2: g.constant(strconv.Itoa(r.hi)), JumpTo: r.target})
+		default:
+			g.emit(Instruction{Op: OpIfLT, Arg1: selector, Arg2: g.constant(strconv.Itoa(r.lo)), JumpTo: labelDefault})
+			g.emit(Instruction{Op: OpIfLE, Arg1: selector, Arg2: g.constant(strconv.Itoa(r.hi)), JumpTo: r.target})
+		}
+		g.emit(Instruction{Op: OpGoto, JumpTo: labelDefault})
+		return
+	}
+	mid := len(ranges) / 2
+	labelHigh := g.newLabel()
+	g.emit(Instruction{Op: OpIfGE, Arg1: selector, Arg2: g.constant(strconv.Itoa(ranges[mid].lo)), JumpTo: labelHigh})
+	g.generateCaseTree(selector, ranges[:mid], aboveLo, labelDefault)
+	g.emit(Instruction{Labels: []string{labelHigh}})
+	g.generateCaseTree(selector, ranges[mid:], true, labelDefault)
+}
+
+// generateJumpTable checks that selector lies within the sorted ranges and
+// jumps through a table with an entry for every value in between. Values no
+// range covers go to labelDefault.
+func (g *Generator) generateJumpTable(selector *symboltable.Symbol, ranges []caseRange, labelDefault string) {
+	lo, hi := ranges[0].lo, ranges[len(ranges)-1].hi
+	first := g.constant(strconv.Itoa(lo))
+	g.emit(Instruction{Op: OpIfLT, Arg1: selector, Arg2: first, JumpTo: labelDefault})
+	g.emit(Instruction{Op: OpIfGT, Arg1: selector, Arg2: g.constant(strconv.Itoa(hi)), JumpTo: labelDefault})
+	targets := make([]string, 0, hi-lo+1)
+	for _, r := range ranges {
+		for v := lo + len(targets); v < r.lo; v++ {
+			targets = append(targets, labelDefault)
+		}
+		for v := r.lo; v <= r.hi; v++ {
+			targets = append(targets, r.target)
+		}
+	}
+	g.emit(Instruction{Op: OpJumpTable, Arg1: selector, Arg2: first, Targets: targets})
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/Meduza3/imp/code"
 	"github.com/Meduza3/imp/symboltable"
+	"github.com/Meduza3/imp/token"
 )
 
 type Op string
@@ -34,9 +36,29 @@ const (
 	OpRead  Op = "read"
 	OpWrite Op = "write"
 
+	// An argument of the following call; Arg2 is the parameter it is
+	// passed for.
 	OpParam Op = "param"
 	OpCall  Op = "call"
 
+	// Around a recursive call the caller's frame (arguments, locals,
+	// temporaries and return address of the procedure in Arg1) is pushed
+	// onto the stack and popped back once the call returns.
+	OpPushFrame Op = "pushframe"
+	OpPopFrame  Op = "popframe"
+
+	// Computed jump to Targets[Arg1 - Arg2]. Arg2 is the constant first
+	// value of the table and Arg1 must already lie within it.
+	OpJumpTable Op = "jumptable"
+
+	// A machine instruction of an ASM block: Opcode with the address of
+	// Arg1, the label JumpTo or the number Operand as its operand.
+	OpAsm Op = "asm"
+
+	// A check, under -bounds, that the index Arg2 lies within dimension
+	// Operand of the multi-dimensional array Arg1.
+	OpCheckIndex Op = "checkindex"
+
 	OpRet       Op = "ret"
 	OpArrayLoad Op = "arrayLoad"
 	OpHalt      Op = "halt"
@@ -51,6 +73,10 @@ type Instruction struct {
 	Arg2        *symboltable.Symbol
 	Arg2Index   string
 	Labels      []string
+	Targets     []string       // labels of an OpJumpTable
+	Opcode      code.Opcode    // machine instruction of an OpAsm
+	Operand     int            // numeric operand of an OpAsm, dimension of an OpCheckIndex
+	Pos         token.Position // source command the instruction was generated from
 }
 
 func (ins Instruction) String() string {
@@ -87,7 +113,7 @@ func (ins Instruction) String() string {
 	case OpIfEQ, OpIfNE, OpIfLT, OpIfLE, OpIfGT, OpIfGE:
 		parts = append(parts, fmt.Sprintf("%s %s[%s], %s[%s] goto %s", ins.Op, ins.Arg1.Name, ins.Arg1Index, ins.Arg2.Name, ins.Arg2Index, ins.JumpTo))
 
-	case OpCall:
+	case OpCall, OpPushFrame, OpPopFrame:
 		parts = append(parts, fmt.Sprintf("%s %s", ins.Op, ins.Arg1.Name))
 	case OpRead, OpWrite, OpParam:
 		if ins.Arg1Index != "" {
@@ -96,6 +122,22 @@ func (ins Instruction) String() string {
 			parts = append(parts, fmt.Sprintf("%s %s", ins.Op, ins.Arg1.Name))
 		}
 
+	case OpJumpTable:
+		parts = append(parts, fmt.Sprintf("%s %s - %s [%s]", ins.Op, ins.Arg1.Name, ins.Arg2.Name, strings.Join(ins.Targets, " ")))
+
+	case OpAsm:
+		switch {
+		case ins.Arg1 != nil:
+			parts = append(parts, fmt.Sprintf("%s %s %s", ins.Op, ins.Opcode, ins.Arg1.Name))
+		case ins.JumpTo != "":
+			parts = append(parts, fmt.Sprintf("%s %s %s", ins.Op, ins.Opcode, ins.JumpTo))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s %d", ins.Op, ins.Opcode, ins.Operand))
+		}
+
+	case OpCheckIndex:
+		parts = append(parts, fmt.Sprintf("%s %s[%d] %s", ins.Op, ins.Arg1.Name, ins.Operand, ins.Arg2.Name))
+
 	case OpHalt, OpRet:
 		parts = append(parts, string(ins.Op))
 	default:
@@ -0,0 +1,34 @@
+package tac
+
+import (
+	"strconv"
+
+	"github.com/Meduza3/imp/ast"
+)
+
+// A failed check writes one of these codes, then the source line of the
+// ASSERT, REQUIRES or ENSURES, and halts the program.
+const (
+	AssertFailed   = -9991
+	RequiresFailed = -9992
+	EnsuresFailed  = -9993
+)
+
+// generateCheck halts the program with failure code when cond does not
+// hold. Nothing is generated unless CheckContracts is set.
+func (g *Generator) generateCheck(cond ast.BoolExpression, failure int, at ast.Node) error {
+	if !g.CheckContracts {
+		return nil
+	}
+	labelOk := g.newLabel()
+	labelFailed := g.newLabel()
+	if err := g.generateCondition(cond, labelOk, labelFailed); err != nil {
+		return err
+	}
+	g.emit(Instruction{Labels: []string{labelFailed}})
+	g.emit(Instruction{Op: OpWrite, Arg1: g.constant(strconv.Itoa(failure))})
+	g.emit(Instruction{Op: OpWrite, Arg1: g.constant(strconv.Itoa(at.Pos().Line))})
+	g.emit(Instruction{Op: OpHalt})
+	g.emit(Instruction{Labels: []string{labelOk}})
+	return nil
+}
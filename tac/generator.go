@@ -1,29 +1,67 @@
 package tac
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/Meduza3/imp/ast"
+	"github.com/Meduza3/imp/callgraph"
+	"github.com/Meduza3/imp/diag"
+	"github.com/Meduza3/imp/prelude"
 	"github.com/Meduza3/imp/symboltable"
+	"github.com/Meduza3/imp/token"
 )
 
+// Generator lowers a program to three-address code. The program is expected
+// to have passed sema.Check; the errors the generator still reports guard
+// its own invariants rather than the user's code.
 type Generator struct {
 	SymbolTable  *symboltable.SymbolTable
 	Instructions []Instruction
-	Errors       []string
+	Errors       diag.List
+
+	// CheckContracts compiles ASSERT, REQUIRES and ENSURES to runtime
+	// checks. Release builds clear it to strip them.
+	CheckContracts bool
+	// BoundsCheck has every index of a multi-dimensional array checked
+	// against its own dimension, which the flattened index cannot tell,
+	// and passes the bounds of arrays along with T parameters.
+	BoundsCheck bool
 
 	labelCount int
 	tempCount  int
 
 	currentProc string
+	calls       *callgraph.Graph // of the program, saying which calls recurse
+	loops       []loopLabels     // enclosing loops, innermost last
+	pos         token.Position   // source position of the command being generated
+	exit        string           // label RETURN jumps to when ENSURES clauses must run first
+}
+
+// loopLabels are the jump targets of BREAK and CONTINUE inside a loop.
+type loopLabels struct {
+	breakTo    string
+	continueTo string
+}
+
+// generateLoopBody generates the commands of a loop whose BREAK jumps to
+// breakTo and whose CONTINUE jumps to continueTo.
+func (g *Generator) generateLoopBody(commands []ast.Command, breakTo, continueTo string) {
+	g.loops = append(g.loops, loopLabels{breakTo: breakTo, continueTo: continueTo})
+	for _, cmd := range commands {
+		if err := g.Generate(cmd); err != nil {
+			g.report(err)
+		}
+	}
+	g.loops = g.loops[:len(g.loops)-1]
 }
 
 func NewGenerator() *Generator {
 	return &Generator{
-		SymbolTable: symboltable.New(),
-		Errors:      make([]string, 0),
+		SymbolTable:    symboltable.New(),
+		CheckContracts: true,
 	}
 }
 
@@ -31,6 +69,40 @@ func (g *Generator) GetSymbolTable() *symboltable.SymbolTable {
 	return g.SymbolTable
 }
 
+// errorf returns an internal error located at node, or at the command being
+// generated when node is nil. sema.Check rejects the programs that would
+// make one, so it marks a mistake of the compiler rather than of the user.
+func (g *Generator) errorf(node ast.Node, format string, args ...interface{}) error {
+	span := diag.At(g.pos)
+	if node != nil {
+		span = diag.SpanOf(node)
+	}
+	return diag.Errorf(diag.ErrCodegen, span, "internal error: "+format, args...)
+}
+
+// report records err. Errors that are not diagnostics yet are internal
+// failures and are placed at the command being generated.
+func (g *Generator) report(err error) {
+	var d *diag.Diagnostic
+	if !errors.As(err, &d) {
+		d = diag.Errorf(diag.ErrCodegen, diag.At(g.pos), "%v", err)
+	}
+	g.Errors.Add(d)
+}
+
+// lookup resolves a variable visible in the current procedure.
+func (g *Generator) lookup(name string, at ast.Node) (*symboltable.Symbol, error) {
+	sym, err := g.SymbolTable.Lookup(name, g.currentProc)
+	if err != nil {
+		return nil, g.errorf(at, "undeclared variable %s", name)
+	}
+	if sym.Kind == symboltable.CONSTANT && !sym.IsLiteral() {
+		// Named constants are replaced by the cell of their literal.
+		return g.constant(strconv.Itoa(sym.Value)), nil
+	}
+	return sym, nil
+}
+
 func (g *Generator) newLabel() string {
 	g.labelCount++
 	return fmt.Sprintf("L%d", g.labelCount)
@@ -48,8 +120,8 @@ func (g *Generator) newTemp() *symboltable.Symbol {
 	return sym
 }
 
-func opFromToken(tk ast.MathExpression) Op {
-	switch tk.Operator.Literal {
+func opFromToken(tk token.Token) Op {
+	switch tk.Literal {
 	case "+":
 		return OpAdd
 	case "-":
@@ -67,28 +139,42 @@ func opFromToken(tk ast.MathExpression) Op {
 }
 
 func (g *Generator) Generate(node ast.Node) error {
+	if cmd, ok := node.(ast.Command); ok {
+		oldPos := g.pos
+		g.pos = cmd.Pos()
+		defer func() { g.pos = oldPos }()
+	}
 	switch node := node.(type) {
 	case *ast.Program:
-		g.SymbolTable.Declare("1", "main", symboltable.Symbol{Name: "1", Kind: symboltable.CONSTANT})
-		bil, _ := g.SymbolTable.Declare("built_in_left", "main", symboltable.Symbol{Name: "built_in_left", Kind: symboltable.DECLARATION})
-		bir, _ := g.SymbolTable.Declare("built_in_right", "main", symboltable.Symbol{Name: "built_in_right", Kind: symboltable.DECLARATION})
-		bir2, _ := g.SymbolTable.Declare("built_in_result", "main", symboltable.Symbol{Name: "built_in_result", Kind: symboltable.DECLARATION})
-		g.SymbolTable.Initialize(bil, "main")
-		g.SymbolTable.Initialize(bir, "main")
-		g.SymbolTable.Initialize(bir2, "main")
-		g.emit(Instruction{Op: OpGoto, JumpTo: "main"})
+		g.calls = callgraph.Build(node)
+		g.constant("1")
+		bil, _ := g.SymbolTable.Declare("built_in_left", symboltable.Global, symboltable.Symbol{Name: "built_in_left", Kind: symboltable.DECLARATION})
+		bir, _ := g.SymbolTable.Declare("built_in_right", symboltable.Global, symboltable.Symbol{Name: "built_in_right", Kind: symboltable.DECLARATION})
+		bir2, _ := g.SymbolTable.Declare("built_in_result", symboltable.Global, symboltable.Symbol{Name: "built_in_result", Kind: symboltable.DECLARATION})
+		g.SymbolTable.Initialize(bil, symboltable.Global)
+		g.SymbolTable.Initialize(bir, symboltable.Global)
+		g.SymbolTable.Initialize(bir2, symboltable.Global)
+		g.emit(Instruction{Op: OpGoto, JumpTo: symboltable.Main})
+		for _, procedure := range prelude.Program().Procedures {
+			if err := g.Generate(procedure); err != nil {
+				g.report(err)
+			}
+		}
+		// Procedures main never calls, such as the rest of an included
+		// library, are left out of the code.
+		used := g.calls.Reachable()
 		for _, procedure := range node.Procedures {
-			if procedure != nil {
+			if procedure != nil && used[procedure.ProcHead.Name.Value] {
 				err := g.Generate(procedure)
 				if err != nil {
-					g.Errors = append(g.Errors, fmt.Sprintf("failed to generate procedure: %v", err))
+					g.report(err)
 				}
 			}
 		}
 		if node.Main != nil {
 			err := g.Generate(node.Main)
 			if err != nil {
-				g.Errors = append(g.Errors, fmt.Sprintf("failed to generate main: %v", err))
+				g.report(err)
 			}
 		}
 		g.emit(Instruction{
@@ -96,33 +182,59 @@ func (g *Generator) Generate(node ast.Node) error {
 		})
 
 	case *ast.Procedure:
-
 		oldProc := g.currentProc
 		g.currentProc = node.ProcHead.Name.Value // e.g. "de"
 		g.SymbolTable.IncreaseOffset(1000)
-		funcSym, _ := g.SymbolTable.Declare(g.currentProc, "xxFunctionsxx", symboltable.Symbol{Name: g.currentProc, Kind: symboltable.PROCEDURE, ArgCount: len(node.ProcHead.ArgsDecl)})
+		funcSym, _ := g.SymbolTable.Declare(g.currentProc, "xxFunctionsxx", symboltable.Symbol{Name: g.currentProc, Kind: symboltable.PROCEDURE, ArgCount: len(node.ProcHead.ArgsDecl), Recursive: node.Recursive, Returns: node.Returns})
 		g.SymbolTable.Declare(g.currentProc+"_return", "xxFunctionsxx", symboltable.Symbol{Name: g.currentProc + "_return", Kind: symboltable.RETURNADDR})
+		if node.Returns {
+			g.SymbolTable.Declare(g.currentProc+"_result", "xxFunctionsxx", symboltable.Symbol{Name: g.currentProc + "_result", Kind: symboltable.RESULT})
+		}
 		g.emit(Instruction{Labels: []string{node.ProcHead.Name.Value}})
+		g.declareConstants(node.Constants)
 		for _, decl := range node.ProcHead.ArgsDecl {
 			sym, err := g.DeclareArgProcedure(decl, g.currentProc)
 			if err != nil {
-				g.Errors = append(g.Errors, err.Error())
+				g.report(err)
 			} else {
 				funcSym.Arguments = append(funcSym.Arguments, sym)
 				funcSym.ArgumentsType = append(funcSym.ArgumentsType, sym.Kind)
 			}
 		}
+		if g.BoundsCheck {
+			g.declareBounds(funcSym)
+		}
 		for _, decl := range node.Declarations {
 			err := g.DeclareProcedure(decl, g.currentProc)
 			if err != nil {
-				g.Errors = append(g.Errors, err.Error())
+				g.report(err)
 			}
 		}
+		for i := range node.ProcHead.Requires {
+			c := &node.ProcHead.Requires[i]
+			if err := g.generateCheck(c.Condition, RequiresFailed, c); err != nil {
+				g.report(err)
+			}
+		}
+		g.exit = ""
+		if g.CheckContracts && len(node.ProcHead.Ensures) > 0 {
+			g.exit = g.newLabel()
+		}
 		for _, comm := range node.Commands {
 			err := g.Generate(comm)
 			if err != nil {
-				g.Errors = append(g.Errors, err.Error())
+				g.report(err)
+			}
+		}
+		if g.exit != "" {
+			g.emit(Instruction{Labels: []string{g.exit}})
+			for i := range node.ProcHead.Ensures {
+				c := &node.ProcHead.Ensures[i]
+				if err := g.generateCheck(c.Condition, EnsuresFailed, c); err != nil {
+					g.report(err)
+				}
 			}
+			g.exit = ""
 		}
 		g.emit(Instruction{Op: OpRet})
 		g.currentProc = oldProc
@@ -130,19 +242,20 @@ func (g *Generator) Generate(node ast.Node) error {
 	case *ast.Main:
 		g.SymbolTable.IncreaseOffset(1000)
 		oldProc := g.currentProc
-		g.currentProc = "main"
-		g.emit(Instruction{Labels: []string{"main"}})
+		g.currentProc = symboltable.Main
+		g.emit(Instruction{Labels: []string{symboltable.Main}})
+		g.declareConstants(node.Constants)
 		for _, decl := range node.Declarations {
 			err := g.DeclareMain(decl)
 			if err != nil {
-				g.Errors = append(g.Errors, err.Error())
+				g.report(err)
 			}
 		}
 
 		for _, comm := range node.Commands {
 			err := g.Generate(comm)
 			if err != nil {
-				g.Errors = append(g.Errors, err.Error())
+				g.report(err)
 			}
 		}
 		g.currentProc = oldProc
@@ -150,38 +263,36 @@ func (g *Generator) Generate(node ast.Node) error {
 		// 1. Generate a place (temp or variable) for the right-hand side
 		place, err := g.generateMathExpression(&node.MathExpression)
 		if err != nil {
-			return nil // or return err, up to you
+			return err
 		}
 		if place == nil {
 			return fmt.Errorf("failed to generate RHS for assignment")
 		}
 		// 2. Emit a final assignment: identifier = place
-		idSymbol, err := g.SymbolTable.Lookup(node.Identifier.Value, g.currentProc)
+		idSymbol, err := g.lookup(node.Identifier.Value, &node.Identifier)
 		if err != nil {
-			return fmt.Errorf("failed to lookup for idSymbol: %v", err)
-		}
-		if idSymbol.Kind == symboltable.ITERATOR {
-			return fmt.Errorf("Nie mozna modyfikowac iteratora petli FOR: %d", node.Token.Line-102)
-		}
-		if isNumber(node.Identifier.Index) {
-			g.SymbolTable.Declare(node.Identifier.Index, "main", symboltable.Symbol{Name: node.Identifier.Index, Kind: symboltable.CONSTANT})
+			return err
 		}
 		if idSymbol.IsTable {
-			if node.Identifier.Index == "" {
-				return fmt.Errorf("Brakuje indeksu dla zmiennej tablicowej %s w lini %d", node.Identifier.String(), node.Token.Line-102)
+			if node.Identifier.Index == nil {
+				return g.errorf(&node.Identifier, "array %s used without an index", node.Identifier.Value)
+			}
+			index, err := g.generateIndex(&node.Identifier)
+			if err != nil {
+				return err
 			}
 			g.emit(Instruction{
 				Op:        OpAssign,
 				Arg1:      idSymbol,
-				Arg1Index: node.Identifier.Index,
+				Arg1Index: index,
 				Arg2:      place,
 			})
 		} else {
 			if idSymbol == nil {
 				return fmt.Errorf("nil idSymbol")
 			}
-			if node.Identifier.Index != "" {
-				return fmt.Errorf("Bledne uzycie zmiennej %s w lini %d", node.Identifier.String(), node.Token.Line-102)
+			if node.Identifier.Index != nil {
+				return g.errorf(&node.Identifier, "%s is not an array", node.Identifier.Value)
 			}
 			g.emit(Instruction{
 				Op:   OpAssign,
@@ -195,43 +306,39 @@ func (g *Generator) Generate(node ast.Node) error {
 
 		var sym *symboltable.Symbol
 		var err error
-		if isNumber(val.String()) {
-			sym, err = g.SymbolTable.Declare(val.String(), "main", symboltable.Symbol{Name: val.String(), Kind: symboltable.CONSTANT})
-			if err != nil {
-			}
-			g.emit(Instruction{Op: OpWrite, Arg1: sym})
-
-			return nil
-		}
 		switch value := val.(type) {
 		case *ast.Identifier:
-			sym, err = g.SymbolTable.Lookup(value.Value, g.currentProc)
+			sym, err = g.lookup(value.Value, value)
 			if err != nil {
-				return fmt.Errorf("failed to generate Write for %v: %v", node, err)
+				return err
 			}
-			if isNumber(value.Index) {
-				_, err = g.SymbolTable.Declare(value.Index, "main", symboltable.Symbol{Name: value.Index, Kind: symboltable.CONSTANT})
-				if err != nil {
+			index := ""
+			if value.Index != nil {
+				if index, err = g.generateIndex(value); err != nil {
+					return err
 				}
 			}
-			g.emit(Instruction{Op: OpWrite, Arg1: sym, Arg1Index: value.Index})
+			g.emit(Instruction{Op: OpWrite, Arg1: sym, Arg1Index: index})
+		default:
+			place, err := g.generateValue(value)
+			if err != nil {
+				return err
+			}
+			g.emit(Instruction{Op: OpWrite, Arg1: &place})
 		}
 	case *ast.ReadCommand:
 		val := node.Identifier
-		var sym *symboltable.Symbol
-		if isNumber(val.String()) {
-			sym, _ = g.SymbolTable.Declare(val.Value, "main", symboltable.Symbol{Name: val.Value, Kind: symboltable.CONSTANT})
-			g.emit(Instruction{Op: OpRead, Arg1: sym})
-			return nil
-		}
-		sym, _ = g.SymbolTable.Lookup(val.Value, g.currentProc)
-		if sym.Kind == symboltable.ITERATOR {
-			return fmt.Errorf("Nie mozna modyfikowac iteratora petli FOR: %d", node.Token.Line-102)
+		sym, err := g.lookup(val.Value, &node.Identifier)
+		if err != nil {
+			return err
 		}
-		if isNumber(val.Index) {
-			g.SymbolTable.Declare(val.Index, "main", symboltable.Symbol{Name: val.Index, Kind: symboltable.CONSTANT})
+		index := ""
+		if val.Index != nil {
+			if index, err = g.generateIndex(&node.Identifier); err != nil {
+				return err
+			}
 		}
-		g.emit(Instruction{Op: OpRead, Arg1: sym, Arg1Index: val.Index})
+		g.emit(Instruction{Op: OpRead, Arg1: sym, Arg1Index: index})
 
 	case *ast.WhileCommand:
 		labelStart := g.newLabel() // e.g. "L1"
@@ -247,11 +354,7 @@ func (g *Generator) Generate(node ast.Node) error {
 		}
 		g.emit(Instruction{Labels: []string{labelBody}})
 
-		for _, cmd := range node.Commands {
-			if err := g.Generate(cmd); err != nil {
-				g.Errors = append(g.Errors, err.Error())
-			}
-		}
+		g.generateLoopBody(node.Commands, labelEnd, labelStart)
 
 		g.emit(Instruction{
 			Op:     OpGoto,
@@ -263,56 +366,16 @@ func (g *Generator) Generate(node ast.Node) error {
 		iteratorName := node.Iterator.Value
 		iteratorSymbol, _ := g.SymbolTable.Declare(iteratorName, g.currentProc, symboltable.Symbol{Name: iteratorName, Kind: symboltable.ITERATOR})
 
-		fullStartVal := node.From.String() // Preserve the original string.
-		startValIndex := ""
-		startVal := fullStartVal
-		if strings.Contains(fullStartVal, "[") {
-			parts := strings.Split(fullStartVal, "[")
-			startVal = parts[0]
-			if len(parts) > 1 {
-				parts[1] = parts[1][:len(parts[1])-1]
-				startValIndex = parts[1]
-			}
-		}
-
-		fullEndVal := node.To.String() // Preserve the original string.
-		endValIndex := ""
-		endVal := fullEndVal
-		if strings.Contains(fullEndVal, "[") {
-			parts := strings.Split(fullEndVal, "[")
-			endVal = parts[0]
-			if len(parts) > 1 {
-				parts[1] = parts[1][:len(parts[1])-1]
-				endValIndex = parts[1]
-			}
-		}
-		// fmt.Printf("ENDVAL INDEX: %v \n", endValIndex)
-		// TODO: this may be a table to check for being a table
-		var startSymbol *symboltable.Symbol
-		_, err := strconv.Atoi(startVal)
-		if err == nil {
-			startSymbol, _ = g.SymbolTable.Declare(startVal, "main", symboltable.Symbol{Name: startVal, Kind: symboltable.CONSTANT})
-		} else {
-			startSymbol, err = g.SymbolTable.Lookup(startVal, g.currentProc)
-			if err != nil {
-				return fmt.Errorf("startSymbol not found! startVal=%v currentProc=%v err=%v", startVal, g.currentProc, err)
-			}
-		}
-		if startSymbol == nil {
-			return fmt.Errorf("nil startSymbol")
+		startSymbol, startValIndex, err := g.generateOperand(node.From)
+		if err != nil {
+			return err
 		}
-		var endSymbol *symboltable.Symbol
-		_, err = strconv.Atoi(endVal)
-		if err == nil {
-			endSymbol, _ = g.SymbolTable.Declare(endVal, "main", symboltable.Symbol{Name: endVal, Kind: symboltable.CONSTANT})
-		} else {
-			endSymbol, err = g.SymbolTable.Lookup(endVal, g.currentProc)
-			if err != nil {
-				return fmt.Errorf("failed to lookup the symbol for upper bound %q: %v", endVal, err)
-			}
+		endSymbol, endValIndex, err := g.generateOperand(node.To)
+		if err != nil {
+			return err
 		}
 
-		oneSymbol, err := g.SymbolTable.Lookup("1", "main")
+		oneSymbol, err := g.SymbolTable.Lookup("1", symboltable.Global)
 		if err != nil {
 			return fmt.Errorf("failed to lookup symbol 1")
 		}
@@ -357,11 +420,9 @@ func (g *Generator) Generate(node ast.Node) error {
 			JumpTo: labelEnd,
 		})
 		g.emit(Instruction{Labels: []string{labelBody}})
-		for _, cmd := range node.Commands {
-			if err := g.Generate(cmd); err != nil {
-				g.Errors = append(g.Errors, err.Error())
-			}
-		}
+		labelNext := g.newLabel()
+		g.generateLoopBody(node.Commands, labelEnd, labelNext)
+		g.emit(Instruction{Labels: []string{labelNext}})
 		if !node.IsDownTo {
 			// fmt.Println("DOWNOTTTT")
 			// i = i + 1
@@ -401,40 +462,62 @@ func (g *Generator) Generate(node ast.Node) error {
 		})
 		g.emit(Instruction{Labels: []string{labelEnd}})
 	case *ast.ProcCallCommand:
-		funcSym, err := g.SymbolTable.Lookup(node.Name.String(), "xxFunctionsxx")
-		if err != nil {
-			return fmt.Errorf("failed looking up function symbol: %v", err)
+		if _, err := g.generateCall(&node.Name, node.Args, node); err != nil {
+			return err
 		}
-		if funcSym.Name == g.currentProc {
-			return fmt.Errorf("Niezdefiniowana procedura %s w linii %d", funcSym.Name, node.Token.Line-102)
+
+	case *ast.CaseCommand:
+		return g.generateCase(node)
+
+	case *ast.AsmCommand:
+		return g.generateAsm(node)
+
+	case *ast.BreakCommand:
+		if len(g.loops) == 0 {
+			return g.errorf(node, "BREAK outside of a loop")
 		}
-		for _, arg := range node.Args {
-			argName := arg.String()
-			symbol, err := g.SymbolTable.Lookup(argName, g.currentProc)
-			if err == nil {
-				g.emit(Instruction{
-					Op:   OpParam,
-					Arg1: symbol,
-				})
-			} else {
-				return fmt.Errorf("failed calling %s: %v", argName, err)
-			}
+		g.emit(Instruction{Op: OpGoto, JumpTo: g.loops[len(g.loops)-1].breakTo})
+
+	case *ast.ContinueCommand:
+		if len(g.loops) == 0 {
+			return g.errorf(node, "CONTINUE outside of a loop")
 		}
+		g.emit(Instruction{Op: OpGoto, JumpTo: g.loops[len(g.loops)-1].continueTo})
 
+	case *ast.ReturnCommand:
+		funcSym, err := g.SymbolTable.Lookup(g.currentProc, "xxFunctionsxx")
+		if err != nil || !funcSym.Returns {
+			return g.errorf(node, "RETURN outside of a function")
+		}
+		place, err := g.generateValue(node.Value)
+		if err != nil {
+			return err
+		}
+		resultSym, err := g.SymbolTable.Lookup(funcSym.Name+"_result", "xxFunctionsxx")
+		if err != nil {
+			return err
+		}
 		g.emit(Instruction{
-			Op:   OpCall,
-			Arg1: funcSym,
+			Op:   OpAssign,
+			Arg1: resultSym,
+			Arg2: &place,
 		})
+		if g.exit != "" {
+			g.emit(Instruction{Op: OpGoto, JumpTo: g.exit})
+		} else {
+			g.emit(Instruction{Op: OpRet})
+		}
+
+	case *ast.AssertCommand:
+		return g.generateCheck(node.Condition, AssertFailed, node)
 
 	case *ast.RepeatCommand:
 		labelStart := g.newLabel()
 		labelEnd := g.newLabel()
+		labelTest := g.newLabel()
 		g.emit(Instruction{Labels: []string{labelStart}})
-		for _, cmd := range node.Commands {
-			if err := g.Generate(cmd); err != nil {
-				g.Errors = append(g.Errors, err.Error())
-			}
-		}
+		g.generateLoopBody(node.Commands, labelEnd, labelTest)
+		g.emit(Instruction{Labels: []string{labelTest}})
 
 		// 4. Generate code to test the condition
 		//    If the condition is true, jump to labelEnd; otherwise jump to labelStart
@@ -468,7 +551,7 @@ func (g *Generator) Generate(node ast.Node) error {
 		g.emit(Instruction{Labels: []string{labelThen}})
 		for _, cmd := range node.ThenCommands {
 			if err := g.Generate(cmd); err != nil {
-				g.Errors = append(g.Errors, err.Error())
+				g.report(err)
 			}
 		}
 
@@ -482,7 +565,7 @@ func (g *Generator) Generate(node ast.Node) error {
 			})
 			for _, cmd := range node.ElseCommands {
 				if err := g.Generate(cmd); err != nil {
-					g.Errors = append(g.Errors, err.Error())
+					g.report(err)
 				}
 			}
 		}
@@ -495,9 +578,421 @@ func (g *Generator) Generate(node ast.Node) error {
 	return nil
 }
 
-func isNumber(str string) bool {
-	_, err := strconv.Atoi(str)
-	return (err == nil)
+// generateCall passes args to the procedure or function name and calls it.
+// Variables are passed by reference; any other value is evaluated into a
+// temporary first. It returns the symbol of the callee.
+func (g *Generator) generateCall(name *ast.Pidentifier, args []ast.Value, at ast.Node) (*symboltable.Symbol, error) {
+	funcSym, err := g.SymbolTable.Lookup(name.Value, "xxFunctionsxx")
+	if err != nil || funcSym.Kind != symboltable.PROCEDURE {
+		return nil, g.errorf(name, "undefined procedure %s", name.Value)
+	}
+	kind := "procedure"
+	if funcSym.Returns {
+		kind = "function"
+	}
+	recursive := g.calls.Recursive(g.currentProc, funcSym.Name)
+	declared := funcSym.ArgCount
+	for _, param := range funcSym.Arguments {
+		declared -= len(param.Bounds)
+	}
+	if len(args) != declared {
+		return nil, g.errorf(at, "%s %s takes %d arguments, got %d", kind, funcSym.Name, declared, len(args))
+	}
+	// Arguments are evaluated before a recursive call saves the frame, so
+	// the saved copy already holds them.
+	params := make([]*symboltable.Symbol, len(args))
+	for i, arg := range args {
+		var param *symboltable.Symbol
+		if i < len(funcSym.Arguments) {
+			param = funcSym.Arguments[i]
+		}
+		params[i], err = g.argument(arg, param, kind, funcSym.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, param := range funcSym.Arguments[:len(args)] {
+		if param.Bounds != nil {
+			params = append(params, g.bounds(params[i])...)
+		}
+	}
+	if recursive {
+		g.constant("1") // the translator steps the stack pointer by it
+		g.emit(Instruction{Op: OpPushFrame, Arg1: funcSym})
+	}
+	for i, param := range params {
+		ins := Instruction{Op: OpParam, Arg1: param}
+		if i < len(funcSym.Arguments) {
+			ins.Arg2 = funcSym.Arguments[i]
+		}
+		g.emit(ins)
+	}
+
+	g.emit(Instruction{
+		Op:   OpCall,
+		Arg1: funcSym,
+	})
+	if recursive {
+		g.emit(Instruction{Op: OpPopFrame, Arg1: funcSym})
+	}
+	return funcSym, nil
+}
+
+// declareBounds gives each T parameter of one dimension of proc the two
+// parameters its bounds are passed in. They follow the parameters of the
+// heading, in the order of the arrays.
+func (g *Generator) declareBounds(proc *symboltable.Symbol) {
+	for _, param := range proc.Arguments {
+		if !param.IsTable || param.Dims != nil {
+			continue
+		}
+		for _, bound := range []string{"from", "to"} {
+			name := param.Name + "'" + bound
+			sym, _ := g.SymbolTable.Declare(name, g.currentProc, symboltable.Symbol{Name: name, Kind: symboltable.ARGUMENT, Mode: symboltable.In})
+			param.Bounds = append(param.Bounds, sym)
+			proc.Arguments = append(proc.Arguments, sym)
+			proc.ArgumentsType = append(proc.ArgumentsType, sym.Kind)
+			proc.ArgCount++
+		}
+	}
+}
+
+// bounds returns the symbols holding the From and To of the array arr
+// passed for a T parameter of one dimension: its own bounds when it is a
+// parameter too, constants otherwise.
+func (g *Generator) bounds(arr *symboltable.Symbol) []*symboltable.Symbol {
+	if arr.Bounds != nil {
+		return arr.Bounds
+	}
+	return []*symboltable.Symbol{g.constant(strconv.Itoa(arr.From)), g.constant(strconv.Itoa(arr.To))}
+}
+
+// argument returns the symbol passed for param, the parameter of the
+// called procedure, or nil when the call has too many arguments. An IN
+// parameter gets a copy of any value. OUT and INOUT ones need a variable
+// the procedure can write through to; other parameters get a copy of
+// values that are not plain variables.
+func (g *Generator) argument(arg ast.Value, param *symboltable.Symbol, kind, procName string) (*symboltable.Symbol, error) {
+	mode := symboltable.ByReference
+	if param != nil && !param.IsTable {
+		mode = param.Mode
+	}
+	id, isVar := arg.(*ast.Identifier)
+	isVar = isVar && id.Index == nil
+	switch mode {
+	case symboltable.In:
+		if isVar {
+			sym, err := g.lookup(id.Value, id)
+			if err != nil {
+				return nil, err
+			}
+			if sym.IsTable {
+				// The translator reports the array passed for a scalar.
+				return sym, nil
+			}
+		}
+		return g.copyArgument(arg)
+	case symboltable.Out, symboltable.InOut:
+		modeName := "OUT"
+		if mode == symboltable.InOut {
+			modeName = "INOUT"
+		}
+		if !isVar {
+			return nil, g.errorf(arg, "%s parameter %s of %s %s needs a variable, got %s", modeName, param.Name, kind, procName, arg)
+		}
+		sym, err := g.lookup(id.Value, id)
+		if err != nil {
+			return nil, err
+		}
+		return sym, nil
+	}
+	if !isVar {
+		return g.argumentTemp(arg)
+	}
+	sym, err := g.lookup(id.Value, id)
+	if err != nil {
+		return nil, err
+	}
+	if sym.Kind == symboltable.CONSTANT || sym.Kind == symboltable.ARGUMENT && sym.Mode == symboltable.In {
+		// A named constant or an IN parameter is passed as a copy the
+		// callee may change.
+		return g.copyArgument(arg)
+	}
+	return sym, nil
+}
+
+// copyArgument evaluates an argument into a fresh temporary, even when it
+// is a plain variable.
+func (g *Generator) copyArgument(v ast.Value) (*symboltable.Symbol, error) {
+	place, err := g.generateValue(v)
+	if err != nil {
+		return nil, err
+	}
+	tmp := g.newTemp()
+	g.emit(Instruction{
+		Op:   OpAssign,
+		Arg1: tmp,
+		Arg2: &place,
+	})
+	return tmp, nil
+}
+
+// argumentTemp evaluates an argument that is not a plain variable into a
+// fresh temporary, so the callee cannot write through to a constant or an
+// array element.
+func (g *Generator) argumentTemp(v ast.Value) (*symboltable.Symbol, error) {
+	place, err := g.generateValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if place.Kind == symboltable.TEMP {
+		return &place, nil
+	}
+	tmp := g.newTemp()
+	g.emit(Instruction{
+		Op:   OpAssign,
+		Arg1: tmp,
+		Arg2: &place,
+	})
+	return tmp, nil
+}
+
+// constant returns the symbol of a numeric literal, declaring it on first use.
+func (g *Generator) constant(lit string) *symboltable.Symbol {
+	value, _ := strconv.Atoi(lit)
+	sym, _ := g.SymbolTable.Declare(lit, symboltable.Global, symboltable.Symbol{Name: lit, Kind: symboltable.CONSTANT, Value: value})
+	return sym
+}
+
+// declareConstants evaluates a CONST section and declares its names in the
+// current procedure.
+func (g *Generator) declareConstants(constants []ast.ConstDeclaration) {
+	for i := range constants {
+		c := &constants[i]
+		value, err := g.evalConstant(c.Value)
+		if err != nil {
+			g.report(err)
+			continue
+		}
+		_, err = g.SymbolTable.Declare(c.Name.Value, g.currentProc, symboltable.Symbol{Name: c.Name.Value, Kind: symboltable.CONSTANT, Value: value, IsInitialized: true})
+		if err != nil {
+			g.report(g.errorf(&c.Name, "%s is already declared", c.Name.Value))
+		}
+	}
+}
+
+// evalConstant computes a constant expression. Division and modulo round
+// towards minus infinity and give 0 for a zero divisor, as at run time.
+func (g *Generator) evalConstant(v ast.Value) (int, error) {
+	switch val := v.(type) {
+	case *ast.NumberLiteral:
+		return strconv.Atoi(val.Value)
+	case *ast.UnaryExpression:
+		right, err := g.evalConstant(val.Right)
+		return -right, err
+	case *ast.Identifier:
+		if val.IsTable {
+			break
+		}
+		sym, err := g.SymbolTable.Lookup(val.Value, g.currentProc)
+		if err != nil {
+			return 0, g.errorf(val, "undeclared constant %s", val.Value)
+		}
+		if sym.Kind != symboltable.CONSTANT {
+			break
+		}
+		return sym.Value, nil
+	case *ast.BinaryExpression:
+		left, err := g.evalConstant(val.Left)
+		if err != nil {
+			return 0, err
+		}
+		right, err := g.evalConstant(val.Right)
+		if err != nil {
+			return 0, err
+		}
+		switch opFromToken(val.Operator) {
+		case OpAdd:
+			return left + right, nil
+		case OpSub:
+			return left - right, nil
+		case OpMul:
+			return left * right, nil
+		case OpDiv:
+			if right == 0 {
+				return 0, nil
+			}
+			q := left / right
+			if (left%right != 0) && ((left < 0) != (right < 0)) {
+				q--
+			}
+			return q, nil
+		case OpMod:
+			if right == 0 {
+				return 0, nil
+			}
+			m := left % right
+			if m != 0 && ((m < 0) != (right < 0)) {
+				m += right
+			}
+			return m, nil
+		}
+	}
+	return 0, g.errorf(v, "%s is not a constant expression", v)
+}
+
+// generateOperand returns a symbol and index an instruction can read v
+// through directly. Only computed values are evaluated into a temporary.
+func (g *Generator) generateOperand(v ast.Value) (*symboltable.Symbol, string, error) {
+	switch val := v.(type) {
+	case *ast.NumberLiteral:
+		return g.constant(val.String()), "", nil
+	case *ast.Identifier:
+		sym, err := g.lookup(val.Value, val)
+		if err != nil || val.Index == nil {
+			return sym, "", err
+		}
+		index, err := g.generateIndex(val)
+		return sym, index, err
+	}
+	place, err := g.generateValue(v)
+	if err != nil {
+		return nil, "", err
+	}
+	return &place, "", nil
+}
+
+// generateIndex returns the name of the symbol holding the index of an array
+// access. Indices that are not a plain variable or number are evaluated into
+// a temporary first.
+func (g *Generator) generateIndex(id *ast.Identifier) (string, error) {
+	arr, err := g.lookup(id.Value, id)
+	if err != nil {
+		return "", err
+	}
+	if len(id.Indices) > 1 || len(arr.Dims) > 1 {
+		return g.generateFlatIndex(id, arr)
+	}
+	if idx, ok := id.Index.(*ast.Identifier); ok && !idx.IsTable {
+		sym, err := g.lookup(idx.Value, idx)
+		if err != nil {
+			return "", err
+		}
+		return sym.Name, nil
+	}
+	place, err := g.generateValue(id.Index)
+	return place.Name, err
+}
+
+// dimensions evaluates the bounds of an array declaration.
+func (g *Generator) dimensions(bounds []ast.Bounds) ([]symboltable.Dimension, error) {
+	dims := make([]symboltable.Dimension, len(bounds))
+	for i, b := range bounds {
+		from, err := g.evalConstant(b.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := g.evalConstant(b.To)
+		if err != nil {
+			return nil, err
+		}
+		dims[i] = symboltable.Dimension{From: from, To: to}
+	}
+	return dims, nil
+}
+
+// generateFlatIndex returns the symbol holding the row-major index of an
+// element of a multi-dimensional array, counted from its first element. With
+// dimension sizes n1..nk, element [i1, ..., ik] is
+// ((i1*n2 + i2)*n3 + ...)*nk + ik less the same sum for the lower bounds, so
+// a T parameter sees its argument element by element whatever bounds either
+// is declared with.
+func (g *Generator) generateFlatIndex(id *ast.Identifier, arr *symboltable.Symbol) (string, error) {
+	dims := max(len(arr.Dims), 1)
+	if len(id.Indices) != dims {
+		return "", g.errorf(id, "array %s has %d dimensions, got %d indices", id.Value, dims, len(id.Indices))
+	}
+	origin := 0
+	for k, dim := range arr.Dims {
+		if k > 0 {
+			origin *= dim.Size()
+		}
+		origin += dim.From
+	}
+	flat, constant := 0, true
+	for k, index := range id.Indices {
+		v, err := g.evalConstant(index)
+		if err != nil {
+			constant = false
+			break
+		}
+		if k > 0 {
+			flat *= arr.Dims[k].Size()
+		}
+		flat += v
+	}
+	if constant {
+		return g.constant(strconv.Itoa(flat - origin)).Name, nil
+	}
+
+	place, err := g.generateValue(id.Indices[0])
+	if err != nil {
+		return "", err
+	}
+	g.checkIndex(arr, 0, &place)
+	acc := &place
+	for k := 1; k < dims; k++ {
+		scaled := g.multiplyByConstant(acc, arr.Dims[k].Size())
+		index, err := g.generateValue(id.Indices[k])
+		if err != nil {
+			return "", err
+		}
+		g.checkIndex(arr, k, &index)
+		acc = g.newTemp()
+		g.emit(Instruction{Op: OpAdd, Destination: acc, Arg1: scaled, Arg2: &index})
+	}
+	if origin != 0 {
+		sum := acc
+		acc = g.newTemp()
+		g.emit(Instruction{Op: OpSub, Destination: acc, Arg1: sum, Arg2: g.constant(strconv.Itoa(origin))})
+	}
+	return acc.Name, nil
+}
+
+// checkIndex emits the check of index against dimension k of arr when
+// bounds are checked. sema has checked literal indices already.
+func (g *Generator) checkIndex(arr *symboltable.Symbol, k int, index *symboltable.Symbol) {
+	if !g.BoundsCheck || index.IsLiteral() {
+		return
+	}
+	g.constant(strconv.Itoa(arr.Dims[k].From))
+	g.constant(strconv.Itoa(arr.Dims[k].To))
+	g.emit(Instruction{Op: OpCheckIndex, Arg1: arr, Arg2: index, Operand: k})
+}
+
+// multiplyByConstant returns x*c for c > 0 using doubling and additions,
+// which is much cheaper than calling the multiplication routine.
+func (g *Generator) multiplyByConstant(x *symboltable.Symbol, c int) *symboltable.Symbol {
+	var result *symboltable.Symbol
+	power := x // x * 2^k
+	for c > 0 {
+		if c&1 == 1 {
+			if result == nil {
+				result = power
+			} else {
+				sum := g.newTemp()
+				g.emit(Instruction{Op: OpAdd, Destination: sum, Arg1: result, Arg2: power})
+				result = sum
+			}
+		}
+		c >>= 1
+		if c > 0 {
+			double := g.newTemp()
+			g.emit(Instruction{Op: OpAdd, Destination: double, Arg1: power, Arg2: power})
+			power = double
+		}
+	}
+	return result
 }
 
 func MergeLabelOnlyInstructions(inss []Instruction) []Instruction {
@@ -540,7 +1035,33 @@ func MergeLabelOnlyInstructions(inss []Instruction) []Instruction {
 	return result
 }
 
-func (g *Generator) generateCondition(cond ast.Condition, labelTrue, labelFalse string) error {
+// generateCondition jumps to labelTrue when cond holds and to labelFalse
+// otherwise. AND and OR short-circuit: the right operand is only evaluated
+// when the left one does not decide the outcome.
+func (g *Generator) generateCondition(cond ast.BoolExpression, labelTrue, labelFalse string) error {
+	switch c := cond.(type) {
+	case *ast.LogicalExpression:
+		labelRight := g.newLabel()
+		var err error
+		if c.Operator.Type == token.AND {
+			err = g.generateCondition(c.Left, labelRight, labelFalse)
+		} else {
+			err = g.generateCondition(c.Left, labelTrue, labelRight)
+		}
+		if err != nil {
+			return err
+		}
+		g.emit(Instruction{Labels: []string{labelRight}})
+		return g.generateCondition(c.Right, labelTrue, labelFalse)
+	case *ast.NotExpression:
+		return g.generateCondition(c.Operand, labelFalse, labelTrue)
+	case *ast.Condition:
+		return g.generateComparison(c, labelTrue, labelFalse)
+	}
+	return fmt.Errorf("unhandled condition type %T", cond)
+}
+
+func (g *Generator) generateComparison(cond *ast.Condition, labelTrue, labelFalse string) error {
 	// 1. Generate code for left and right
 	left, err := g.generateValue(cond.Left)
 	if err != nil {
@@ -602,66 +1123,90 @@ func mapConditionOp(op string) (Op, error) {
 func (g *Generator) generateValue(v ast.Value) (symboltable.Symbol, error) {
 	switch val := v.(type) {
 	case *ast.UnaryExpression:
+		if !val.IsLiteral() {
+			// -x is computed as 0 - x
+			rightPlace, err := g.generateValue(val.Right)
+			if err != nil {
+				return symboltable.Symbol{}, err
+			}
+			tmp := g.newTemp()
+			g.emit(Instruction{
+				Op:          OpSub,
+				Destination: tmp,
+				Arg1:        g.constant("0"),
+				Arg2:        &rightPlace,
+			})
+			return *tmp, nil
+		}
 		numStr := val.Right.String()
 		numStr = "-" + numStr
-		sym, _ := g.SymbolTable.Declare(numStr, "main", symboltable.Symbol{
-			Name: numStr,
-			Kind: symboltable.CONSTANT,
-		})
-		return *sym, nil
+		return *g.constant(numStr), nil
 	case *ast.NumberLiteral:
 		numStr := val.String()
 		// Declare the number as a constant
-		sym, _ := g.SymbolTable.Declare(numStr, "main", symboltable.Symbol{
-			Name: numStr,
-			Kind: symboltable.CONSTANT,
-		})
+		sym := g.constant(numStr)
 		// If negative, also declare its absolute value
 		if strings.HasPrefix(numStr, "-") {
-			posVal := numStr[1:]
-			g.SymbolTable.Declare(posVal, "main", symboltable.Symbol{
-				Name: posVal,
-				Kind: symboltable.CONSTANT,
-			})
+			g.constant(numStr[1:])
 		}
 		return *sym, nil
 
+	case *ast.BinaryExpression:
+		tmp, err := g.generateBinary(val.Left, val.Operator, val.Right)
+		if err != nil {
+			return symboltable.Symbol{}, err
+		}
+		return *tmp, nil
+
+	case *ast.FunctionCall:
+		funcSym, err := g.generateCall(&val.Name, val.Args, val)
+		if err != nil {
+			return symboltable.Symbol{}, err
+		}
+		resultSym, err := g.SymbolTable.Lookup(funcSym.Name+"_result", "xxFunctionsxx")
+		if err != nil {
+			return symboltable.Symbol{}, err
+		}
+		// Copy the result out before another call overwrites it.
+		tmp := g.newTemp()
+		g.emit(Instruction{
+			Op:   OpAssign,
+			Arg1: tmp,
+			Arg2: resultSym,
+		})
+		return *tmp, nil
+
 	case *ast.Identifier:
 		// Handle array indices
-		if val.Index != "" {
+		if val.Index != nil {
 			// Generate code for array element access
-			arrSym, err := g.SymbolTable.Lookup(val.Value, g.currentProc)
+			arrSym, err := g.lookup(val.Value, val)
 			if err != nil {
 				return symboltable.Symbol{}, err
 			}
 
-			// Create temporary for the loaded value
-			tmp := g.newTemp()
-
 			// Generate index calculation
-			var indexSym *symboltable.Symbol
-			if isNumber(val.Index) {
-				indexSym, _ = g.SymbolTable.Declare(val.Index, "main", symboltable.Symbol{Name: val.Index, Kind: symboltable.CONSTANT})
-			} else {
-				indexSym, err = g.SymbolTable.Lookup(val.Index, g.currentProc)
-			}
+			index, err := g.generateIndex(val)
 			if err != nil {
 				return symboltable.Symbol{}, err
 			}
 
+			// Create temporary for the loaded value
+			tmp := g.newTemp()
+
 			// Emit array load instructions
 			g.emit(Instruction{
 				Op:        OpAssign,
 				Arg1:      tmp,
 				Arg2:      arrSym,
-				Arg2Index: indexSym.Name,
+				Arg2Index: index,
 			})
 
 			return *tmp, nil
 		}
-		sym, err := g.SymbolTable.Lookup(val.String(), g.currentProc)
+		sym, err := g.lookup(val.String(), val)
 		if err != nil {
-			return symboltable.Symbol{}, fmt.Errorf("failed to lookup symbol %s :%v", v.String(), err)
+			return symboltable.Symbol{}, err
 		}
 		return *sym, nil
 
@@ -674,18 +1219,25 @@ func (g *Generator) generateValue(v ast.Value) (symboltable.Symbol, error) {
 // If expression.Right is nil, there's no operator, so just return Left's place.
 // Otherwise, emit an instruction to combine Left and Right.
 func (g *Generator) generateMathExpression(me *ast.MathExpression) (*symboltable.Symbol, error) {
-	leftPlace, err := g.generateValue(me.Left)
-	if err != nil {
-		return nil, err
-	}
-
 	// If there's no operator, it's just a single operand
 	if me.Right == nil {
+		leftPlace, err := g.generateValue(me.Left)
+		if err != nil {
+			return nil, err
+		}
 		return &leftPlace, nil
 	}
+	return g.generateBinary(me.Left, me.Operator, me.Right)
+}
 
-	// We have an operator and a right operand
-	rightPlace, err := g.generateValue(me.Right)
+// generateBinary evaluates both operands, which may be nested expressions,
+// and returns a fresh temporary holding left op right.
+func (g *Generator) generateBinary(left ast.Value, operator token.Token, right ast.Value) (*symboltable.Symbol, error) {
+	leftPlace, err := g.generateValue(left)
+	if err != nil {
+		return nil, err
+	}
+	rightPlace, err := g.generateValue(right)
 	if err != nil {
 		return nil, err
 	}
@@ -694,24 +1246,24 @@ func (g *Generator) generateMathExpression(me *ast.MathExpression) (*symboltable
 	tmp := g.newTemp()
 
 	// Map the token to our Op enum
-	op := opFromToken(*me)
+	op := opFromToken(operator)
 	if op == OpDiv {
-		if me.Right.String() == "2" {
+		if right.String() == "2" {
 			goto exit
 		} else {
 			funcSym, err := g.SymbolTable.Lookup("built_in_div", "xxFunctionsxx")
 			if err != nil {
 				return nil, err
 			}
-			leftSym, err := g.SymbolTable.Lookup("built_in_left", "main")
+			leftSym, err := g.SymbolTable.Lookup("built_in_left", symboltable.Global)
 			if err != nil {
 				return nil, err
 			}
-			rightSym, err := g.SymbolTable.Lookup("built_in_right", "main")
+			rightSym, err := g.SymbolTable.Lookup("built_in_right", symboltable.Global)
 			if err != nil {
 				return nil, err
 			}
-			resultSym, err := g.SymbolTable.Lookup("built_in_result", "main")
+			resultSym, err := g.SymbolTable.Lookup("built_in_result", symboltable.Global)
 			if err != nil {
 				return nil, err
 			}
@@ -743,15 +1295,15 @@ func (g *Generator) generateMathExpression(me *ast.MathExpression) (*symboltable
 		if err != nil {
 			return nil, err
 		}
-		leftSym, err := g.SymbolTable.Lookup("built_in_left", "main")
+		leftSym, err := g.SymbolTable.Lookup("built_in_left", symboltable.Global)
 		if err != nil {
 			return nil, err
 		}
-		rightSym, err := g.SymbolTable.Lookup("built_in_right", "main")
+		rightSym, err := g.SymbolTable.Lookup("built_in_right", symboltable.Global)
 		if err != nil {
 			return nil, err
 		}
-		resultSym, err := g.SymbolTable.Lookup("built_in_result", "main")
+		resultSym, err := g.SymbolTable.Lookup("built_in_result", symboltable.Global)
 		if err != nil {
 			return nil, err
 		}
@@ -782,15 +1334,15 @@ func (g *Generator) generateMathExpression(me *ast.MathExpression) (*symboltable
 		if err != nil {
 			return nil, err
 		}
-		leftSym, err := g.SymbolTable.Lookup("built_in_left", "main")
+		leftSym, err := g.SymbolTable.Lookup("built_in_left", symboltable.Global)
 		if err != nil {
 			return nil, err
 		}
-		rightSym, err := g.SymbolTable.Lookup("built_in_right", "main")
+		rightSym, err := g.SymbolTable.Lookup("built_in_right", symboltable.Global)
 		if err != nil {
 			return nil, err
 		}
-		resultSym, err := g.SymbolTable.Lookup("built_in_result", "main")
+		resultSym, err := g.SymbolTable.Lookup("built_in_result", symboltable.Global)
 		if err != nil {
 			return nil, err
 		}
@@ -832,6 +1384,9 @@ exit:
 }
 
 func (g *Generator) emit(ins Instruction) {
+	if !ins.Pos.IsValid() {
+		ins.Pos = g.pos
+	}
 	g.Instructions = append(g.Instructions, ins)
 }
 
@@ -850,48 +1405,81 @@ func (g *Generator) DeclareArgProcedure(decl ast.ArgDecl, procName string) (*sym
 		IsTable:       isTable,
 		ArgumentIndex: argCount + 1,
 	}
+	switch decl.Mode.Type {
+	case token.IN:
+		symbol.Mode = symboltable.In
+	case token.OUT:
+		symbol.Mode = symboltable.Out
+	case token.INOUT:
+		symbol.Mode = symboltable.InOut
+	}
+	if len(decl.Dims) > 1 {
+		dims, err := g.dimensions(decl.Dims)
+		if err != nil {
+			return nil, err
+		}
+		symbol.Dims = dims
+	}
 	sym, err := g.SymbolTable.Declare(name, procName, symbol)
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare argument %v in procedure %s: %v", decl, procName, err)
+		return nil, g.errorf(&decl.Name, "parameter %s is already declared in procedure %s", name, procName)
 	}
 	return sym, nil
 }
 
 func (g *Generator) DeclareProcedure(decl ast.Declaration, procName string) error {
 	name := decl.Pidentifier.Value
-	isTable := decl.IsTable
-	symbol := symboltable.Symbol{
-		Name:    name,
-		Kind:    symboltable.DECLARATION,
-		IsTable: isTable,
+	symbol, err := g.declarationSymbol(decl)
+	if err != nil {
+		return err
 	}
 	// Check if the symbol already exists
 	if got, _ := g.SymbolTable.Lookup(name, procName); got != nil {
-		return fmt.Errorf("failed to declare %v in procedure %s: identifier %q already declared", decl, procName, name)
+		return g.errorf(&decl.Pidentifier, "%s is already declared in procedure %s", name, procName)
 	}
 
 	// Attempt to declare it
 	if _, err := g.SymbolTable.Declare(name, procName, symbol); err != nil {
-		return fmt.Errorf("failed to declare %v in procedure %s: %v", decl, procName, err)
+		return g.errorf(&decl.Pidentifier, "%s is already declared in procedure %s", name, procName)
 	}
 
 	return nil
 }
 
 func (g *Generator) DeclareMain(decl ast.Declaration) error {
+	name := decl.Pidentifier.Value
+	symbol, err := g.declarationSymbol(decl)
+	if err != nil {
+		return err
+	}
+	_, err = g.SymbolTable.Declare(name, symboltable.Main, symbol)
+	if err != nil {
+		return g.errorf(&decl.Pidentifier, "%s is already declared", name)
+	}
+	return nil
+}
+
+// declarationSymbol builds the symbol for a declared variable, with the
+// bounds and size of an array.
+func (g *Generator) declarationSymbol(decl ast.Declaration) (symboltable.Symbol, error) {
 	name := decl.Pidentifier.Value
 	var symbol symboltable.Symbol
 	if decl.IsTable {
-		from, err := strconv.Atoi(decl.From.Value)
-		g.SymbolTable.Declare(decl.From.Value, "main", symboltable.Symbol{Name: decl.From.Value, Kind: symboltable.CONSTANT})
+		dims, err := g.dimensions(append([]ast.Bounds{{From: decl.From, To: decl.To}}, decl.Inner...))
 		if err != nil {
-			return fmt.Errorf("failed parsing from value in main declaration %v. value: %s", decl, decl.From.Value)
+			return symbol, err
 		}
-		to, err := strconv.Atoi(decl.To.Value)
-		g.SymbolTable.Declare(decl.To.Value, "main", symboltable.Symbol{Name: decl.To.Value, Kind: symboltable.CONSTANT})
-		if err != nil {
-			return fmt.Errorf("failed parsing to value in main declaration %v. value: %s", decl, decl.To.Value)
+		from, to := dims[0].From, dims[0].To
+		if len(dims) > 1 {
+			// Row-major flattening counts the elements from the first.
+			size := 1
+			for _, dim := range dims {
+				size *= dim.Size()
+			}
+			from, to = 0, size-1
 		}
+		g.constant(strconv.Itoa(from))
+		g.constant(strconv.Itoa(to))
 		symbol = symboltable.Symbol{
 			Name:    name,
 			Kind:    symboltable.DECLARATION,
@@ -900,15 +1488,14 @@ func (g *Generator) DeclareMain(decl ast.Declaration) error {
 			To:      to,
 			Size:    to - from + 1,
 		}
+		if len(dims) > 1 {
+			symbol.Dims = dims
+		}
 	} else {
 		symbol = symboltable.Symbol{
 			Name: name,
 			Kind: symboltable.DECLARATION,
 		}
 	}
-	_, err := g.SymbolTable.Declare(name, "main", symbol)
-	if err != nil {
-		return fmt.Errorf("failed to declare in main: %v", err)
-	}
-	return nil
+	return symbol, nil
 }
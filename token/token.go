@@ -1,10 +1,50 @@
 package token
 
+import "fmt"
+
+// Position is a location in a source file. Line and Column are 1-based,
+// Offset is the 0-based byte offset. The zero value is an unknown position.
+type Position struct {
+	File   string
+	Offset int
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	s := p.File
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
-	Line    int
+	Pos     Position // position of the first character
+}
+
+// End returns the position just past the last character of the token.
+func (t Token) End() Position {
+	if t.Type == EOF || !t.Pos.IsValid() {
+		return t.Pos
+	}
+	end := t.Pos
+	end.Offset += len(t.Literal)
+	end.Column += len(t.Literal)
+	return end
 }
+
 type TokenType string
 
 const (
@@ -34,6 +74,29 @@ const (
 	ENDFOR                = "ENDFOR"
 	READ                  = "READ"
 	WRITE                 = "WRITE"
+	AND                   = "AND"
+	OR                    = "OR"
+	NOT                   = "NOT"
+	RECURSIVE             = "RECURSIVE"
+	FUNCTION              = "FUNCTION"
+	RETURNS               = "RETURNS"
+	RETURN                = "RETURN"
+	BREAK                 = "BREAK"
+	CONTINUE              = "CONTINUE"
+	CASE                  = "CASE"
+	OF                    = "OF"
+	ENDCASE               = "ENDCASE"
+	CONST                 = "CONST"
+	INCLUDE               = "INCLUDE"
+	ASM                   = "ASM"
+	ASSERT                = "ASSERT"
+	REQUIRES              = "REQUIRES"
+	ENSURES               = "ENSURES"
+	ENDASM                = "ENDASM"
+	IN                    = "IN"
+	OUT                   = "OUT"
+	INOUT                 = "INOUT"
+	RANGE                 = ".."
 	LPAREN                = "("
 	RPAREN                = ")"
 	COMMA                 = ","
@@ -55,6 +118,8 @@ const (
 	COMMENT               = "#"
 	NUM                   = "NUM"
 	PIDENTIFIER           = "PIDENTIFIER"
+	STRING                = "STRING" // double-quoted, the literal keeps the quotes
+	OPCODE                = "OPCODE" // machine instruction in an ASM block
 )
 
 var keywords = map[string]TokenType{
@@ -79,6 +144,28 @@ var keywords = map[string]TokenType{
 	"ENDFOR":    ENDFOR,
 	"READ":      READ,
 	"WRITE":     WRITE,
+	"AND":       AND,
+	"OR":        OR,
+	"NOT":       NOT,
+	"RECURSIVE": RECURSIVE,
+	"FUNCTION":  FUNCTION,
+	"RETURNS":   RETURNS,
+	"RETURN":    RETURN,
+	"BREAK":     BREAK,
+	"CONTINUE":  CONTINUE,
+	"CASE":      CASE,
+	"OF":        OF,
+	"ENDCASE":   ENDCASE,
+	"CONST":     CONST,
+	"INCLUDE":   INCLUDE,
+	"ASM":       ASM,
+	"ASSERT":    ASSERT,
+	"REQUIRES":  REQUIRES,
+	"ENSURES":   ENSURES,
+	"ENDASM":    ENDASM,
+	"IN":        IN,
+	"OUT":       OUT,
+	"INOUT":     INOUT,
 	"T":         T,
 }
 
@@ -0,0 +1,156 @@
+package translator
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Meduza3/imp/code"
+	"github.com/Meduza3/imp/symboltable"
+	"github.com/Meduza3/imp/tac"
+)
+
+// BoundsFailed is written first when an index is out of range. The source
+// line, the index and the character codes of the array's name follow, one
+// number each, before the program halts.
+const BoundsFailed = -9994
+
+// boundsFailure is the code reporting one out-of-range access, emitted
+// after the program so passing checks only cost their jumps.
+type boundsFailure struct {
+	label string
+	array *symboltable.Symbol
+	index *symboltable.Symbol
+	line  int
+}
+
+// checkBounds emits range checks for the indexed operands of ins, with
+// labels on the first instruction. It reports whether it emitted any.
+// Arrays passed as T parameters of one dimension are checked against the
+// bounds the caller passes with them. Multi-dimensional arrays are checked
+// one index at a time by OpCheckIndex instead, since their flattened index
+// can be in range while one of its indices is not.
+func (t *Translator) checkBounds(ins tac.Instruction, labels []string) (bool, error) {
+	emitted := false
+	operands := []struct {
+		array *symboltable.Symbol
+		index string
+	}{{ins.Arg1, ins.Arg1Index}, {ins.Arg2, ins.Arg2Index}}
+	for _, operand := range operands {
+		array := operand.array
+		if operand.index == "" || array == nil || !array.IsTable || len(array.Dims) > 0 {
+			continue
+		}
+		index, err := t.getSymbol(operand.index)
+		if err != nil {
+			return emitted, misuse("invalid array index: %v", err)
+		}
+		var from, to *symboltable.Symbol
+		if array.Kind == symboltable.ARGUMENT {
+			if len(array.Bounds) != 2 {
+				continue
+			}
+			from, to = array.Bounds[0], array.Bounds[1]
+		} else if from, to, err = t.dimension(symboltable.Dimension{From: array.From, To: array.To}); err != nil {
+			return emitted, err
+		}
+		checked, err := t.checkRange(array, index, from, to, ins.Pos.Line, labels)
+		if err != nil {
+			return emitted, err
+		}
+		if checked {
+			labels = nil
+			emitted = true
+		}
+	}
+	return emitted, nil
+}
+
+// handleCheckIndex checks an index of a multi-dimensional array against its
+// dimension. The index is never a literal, which sema checks already.
+func (t *Translator) handleCheckIndex(ins tac.Instruction, labels []string) error {
+	index, err := t.getSymbol(ins.Arg2.Name)
+	if err != nil {
+		return misuse("invalid array index: %v", err)
+	}
+	from, to, err := t.dimension(ins.Arg1.Dims[ins.Operand])
+	if err != nil {
+		return err
+	}
+	_, err = t.checkRange(ins.Arg1, index, from, to, ins.Pos.Line, labels)
+	return err
+}
+
+// dimension returns the constants bounding dim.
+func (t *Translator) dimension(dim symboltable.Dimension) (from, to *symboltable.Symbol, err error) {
+	if from, err = t.getSymbol(strconv.Itoa(dim.From)); err != nil {
+		return nil, nil, err
+	}
+	if to, err = t.getSymbol(strconv.Itoa(dim.To)); err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+// checkRange emits a jump to a bounds failure when index lies outside from
+// to to, with labels on the first instruction. The bounds are constants, or
+// the parameters a T parameter's bounds are passed in. It reports whether
+// it emitted anything: a literal index known to be in range needs no check.
+func (t *Translator) checkRange(array, index, from, to *symboltable.Symbol, line int, labels []string) (bool, error) {
+	if index.IsLiteral() && from.Kind == symboltable.CONSTANT && to.Kind == symboltable.CONSTANT && index.Value >= from.Value && index.Value <= to.Value {
+		return false, nil
+	}
+
+	failure := boundsFailure{
+		label: fmt.Sprintf("$bounds%d", len(t.boundsFailures)),
+		array: array,
+		index: index,
+		line:  line,
+	}
+	t.boundsFailures = append(t.boundsFailures, failure)
+	comment := fmt.Sprintf("check %s[%s] in %s..%s", array.Name, index.Name, from.Name, to.Name)
+	t.loadValue(index, labels, comment)
+	t.subtract(from)
+	t.emit(code.Instruction{Op: code.JNEG, HasOperand: true, Destination: failure.label})
+	t.loadValue(index, nil, "")
+	t.subtract(to)
+	t.emit(code.Instruction{Op: code.JPOS, HasOperand: true, Destination: failure.label})
+	return true, nil
+}
+
+// subtract subtracts the value of a scalar from ACC.
+func (t *Translator) subtract(sym *symboltable.Symbol) {
+	op := code.SUB
+	if sym.Kind == symboltable.ARGUMENT {
+		op = code.SUBI
+	}
+	t.emit(code.Instruction{Op: op, HasOperand: true, Operand: sym.Address})
+}
+
+// loadValue loads the value of a scalar into ACC.
+func (t *Translator) loadValue(sym *symboltable.Symbol, labels []string, comment string) {
+	op := code.LOAD
+	if sym.Kind == symboltable.ARGUMENT {
+		op = code.LOADI
+	}
+	t.emit(code.Instruction{Op: op, HasOperand: true, Operand: sym.Address, Labels: labels, Comment: comment})
+}
+
+// emitBoundsFailures emits the code the failed range checks jump to.
+func (t *Translator) emitBoundsFailures() {
+	for _, failure := range t.boundsFailures {
+		values := []int{BoundsFailed, failure.line}
+		labels := []string{failure.label}
+		for _, value := range values {
+			t.emit(code.Instruction{Op: code.SET, HasOperand: true, Operand: value, Labels: labels, Comment: "index out of range of " + failure.array.Name})
+			t.emit(code.Instruction{Op: code.PUT, HasOperand: true, Operand: 0})
+			labels = nil
+		}
+		t.loadValue(failure.index, nil, "")
+		t.emit(code.Instruction{Op: code.PUT, HasOperand: true, Operand: 0})
+		for _, c := range []byte(failure.array.Name) {
+			t.emit(code.Instruction{Op: code.SET, HasOperand: true, Operand: int(c)})
+			t.emit(code.Instruction{Op: code.PUT, HasOperand: true, Operand: 0})
+		}
+		t.emit(code.Instruction{Op: code.HALT})
+	}
+}
@@ -1,11 +1,14 @@
 package translator
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/Meduza3/imp/code"
+	"github.com/Meduza3/imp/diag"
 	"github.com/Meduza3/imp/symboltable"
 	"github.com/Meduza3/imp/tac"
 )
@@ -19,36 +22,52 @@ type Translator struct {
 	currentOlderFunctionName string
 	procEntries              map[string]int // Adresy początków procedur
 	labels                   map[string]int // Etykiety na adresy
-	errors                   []string
+	errors                   diag.List
 	paramCount               int
 	paramTypes               []symboltable.SymbolKind
 	paramTable               []bool
-	initializedEntries       map[string]bool
+	frame                    *symboltable.Symbol // procedure whose frame was pushed for the call being set up
+
+	// BoundsCheck emits a range check before every access to an element
+	// of a declared array.
+	BoundsCheck    bool
+	boundsFailures []boundsFailure
 }
 
-func (t *Translator) Errors() []string {
+func (t *Translator) Errors() diag.List {
 	return t.errors
 }
 
+// addError records err at the source position ins was generated from.
+// Errors that are not diagnostics are internal failures of the translator:
+// sema has already rejected the programs it cannot translate.
+func (t *Translator) addError(ins tac.Instruction, err error) {
+	var d *diag.Diagnostic
+	if !errors.As(err, &d) {
+		d = diag.Errorf(diag.ErrCodegen, diag.At(ins.Pos), "internal error: cannot translate %v: %v", ins, err)
+	}
+	if !d.Span.Start.IsValid() {
+		d.Span = diag.At(ins.Pos)
+	}
+	t.errors.Add(d)
+}
+
+// misuse reports an array used as a scalar or the other way round.
+func misuse(format string, args ...interface{}) error {
+	return diag.Errorf(diag.ErrArrayMisuse, diag.Span{}, format, args...)
+}
+
 func New(st symboltable.SymbolTable) *Translator {
-	return &Translator{pointerCell: st.CurrentOffset + 10, St: st, procEntries: make(map[string]int), labels: make(map[string]int), initializedEntries: make(map[string]bool)}
+	return &Translator{pointerCell: st.CurrentOffset + 10, St: st, procEntries: make(map[string]int), labels: make(map[string]int)}
 }
 
 func (t *Translator) Translate(tac []tac.Instruction) []code.Instruction {
-	t.InitializeName("built_in_right")
-	t.InitializeName("built_in_left")
-	t.InitializeName("built_in_result")
 	t.firstPass(tac)
+	t.emitBoundsFailures()
 	output := t.secondPass(t.Output)
 	t.Output = output
 	return t.Output
 }
-func (t *Translator) InitializeName(sym string) {
-	t.initializedEntries[sym] = true
-}
-func (t *Translator) Initialize(sym *symboltable.Symbol) {
-	t.initializedEntries[sym.Name] = true
-}
 func (t *Translator) secondPass(input []code.Instruction) []code.Instruction {
 	// 1) Collect all labels => line index
 	labelAddress := make(map[string]int)
@@ -78,15 +97,15 @@ func (t *Translator) secondPass(input []code.Instruction) []code.Instruction {
 }
 
 func (t *Translator) setupConstants() {
-	// 1) First declare/store all nonnegative constants
+	// 1) First declare/store all nonnegative constants. Named constants
+	// are replaced by their literal and need no cell of their own.
 	for _, table := range t.St.Table {
 		for _, value := range table {
-			val, _ := strconv.Atoi(value.Name)
-			if value.Kind == symboltable.CONSTANT {
+			if value.IsLiteral() {
 				t.emit(code.Instruction{
 					Op:         code.SET,
 					HasOperand: true,
-					Operand:    val,
+					Operand:    value.Value,
 					Comment:    "declaring constant " + value.Name,
 				})
 				t.emit(code.Instruction{Op: code.STORE, HasOperand: true, Operand: value.Address, Comment: "$1"})
@@ -97,6 +116,7 @@ func (t *Translator) setupConstants() {
 
 func (t *Translator) firstPass(inss []tac.Instruction) {
 	t.setupConstants()
+	t.setupStack()
 
 	for _, ins := range inss {
 		// fmt.Println("# ins: ", ins.String())
@@ -115,6 +135,15 @@ func (t *Translator) firstPass(inss []tac.Instruction) {
 				labels = append(labels, label)
 			}
 		}
+		if t.BoundsCheck {
+			emitted, err := t.checkBounds(ins, labels)
+			if err != nil {
+				t.addError(ins, err)
+			}
+			if emitted {
+				labels, ins.Labels = nil, nil
+			}
+		}
 
 		switch ins.Op {
 		//----------------------------------------------------------------------
@@ -123,7 +152,7 @@ func (t *Translator) firstPass(inss []tac.Instruction) {
 		case tac.OpAssign: // e.g. "b" or maybe "5"
 			err := t.handleAssign(ins)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("failed to handle Assign %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 
 		//----------------------------------------------------------------------
@@ -133,7 +162,7 @@ func (t *Translator) firstPass(inss []tac.Instruction) {
 			// For example:  ins = { Op: OpAdd, Destination: "x", Arg1: "a", Arg2: "b" }
 			err := t.handleAddSub(ins)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("failed to handleAddSub %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 		case tac.OpGoto:
 			// "goto L"
@@ -146,12 +175,12 @@ func (t *Translator) firstPass(inss []tac.Instruction) {
 		case tac.OpRead:
 			err := t.handleRead(ins)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 		case tac.OpWrite:
 			err := t.handleWrite(ins)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 		case tac.OpHalt:
 			t.handleHalt(labels)
@@ -162,25 +191,47 @@ func (t *Translator) firstPass(inss []tac.Instruction) {
 		case tac.OpCall:
 			err := t.handleCall(ins)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v: %v", ins, err))
+				t.addError(ins, err)
+			}
+		case tac.OpAsm:
+			t.handleAsm(ins, labels)
+		case tac.OpCheckIndex:
+			err := t.handleCheckIndex(ins, labels)
+			if err != nil {
+				t.addError(ins, err)
+			}
+		case tac.OpJumpTable:
+			err := t.handleJumpTable(ins, labels)
+			if err != nil {
+				t.addError(ins, err)
+			}
+		case tac.OpPushFrame:
+			err := t.handlePushFrame(ins.Arg1, labels)
+			if err != nil {
+				t.addError(ins, err)
+			}
+		case tac.OpPopFrame:
+			err := t.handlePopFrame(ins.Arg1, labels)
+			if err != nil {
+				t.addError(ins, err)
 			}
 		case tac.OpParam:
 			err := t.handleParam(ins.Arg1, labels)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 		case tac.OpRet:
 			err := t.handleRet(labels)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 		case tac.OpDiv:
 			err := t.handleDiv(ins)
 			if err != nil {
-				t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v: %v", ins, err))
+				t.addError(ins, err)
 			}
 		default:
-			t.errors = append(t.errors, fmt.Sprintf("Failed to translate %v", ins))
+			t.addError(ins, fmt.Errorf("unsupported instruction"))
 		}
 
 	}
@@ -190,7 +241,6 @@ func (t *Translator) handleRead(ins tac.Instruction) error {
 	if ins.Arg1 == nil {
 		panic("nil arg1!!!")
 	}
-	t.Initialize(ins.Arg1)
 	if ins.Arg1.Kind == symboltable.ARGUMENT {
 		if ins.Arg1.IsTable {
 			idxSym, err := t.getSymbol(ins.Arg1Index)
@@ -300,9 +350,6 @@ func (t *Translator) handleWrite(ins tac.Instruction) error {
 	if ins.Arg1 == nil {
 		panic("WRITE instruction has nil Arg1")
 	}
-	if ins.Arg1.Kind == symboltable.DECLARATION && !ins.Arg1.IsTable && t.initializedEntries[ins.Arg1.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", ins.Arg1.Name)
-	}
 	if ins.Arg1.Kind == symboltable.ARGUMENT {
 		if ins.Arg1.IsTable {
 			idxSym, err := t.getSymbol(ins.Arg1Index)
@@ -404,10 +451,6 @@ func (t *Translator) handleAssign(ins tac.Instruction) error {
 	if ins.Arg1 == nil || ins.Arg2 == nil {
 		return fmt.Errorf("nil argument in assignment instruction: %v", ins)
 	}
-	if ins.Arg2.Kind == symboltable.DECLARATION && !ins.Arg2.IsTable && t.initializedEntries[ins.Arg2.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", ins.Arg2.Name)
-	}
-	t.Initialize(ins.Arg1)
 	dest := ins.Arg1
 	destIndex := ins.Arg1Index
 	src := ins.Arg2
@@ -419,22 +462,22 @@ func (t *Translator) handleAssign(ins tac.Instruction) error {
 		return t.handleArrayToArrayAssign(*dest, *src, destIndex, srcIndex, label)
 	} else if src.IsTable {
 		if destIndex != "" {
-			return fmt.Errorf("Bledne uzycie zmiennej %s", dest.Name)
+			return misuse("invalid use of array %s", dest.Name)
 		}
 		// Case 3: Array Element to Variable (a := x[n])
 		return t.handleArrayToVarAssign(*dest, *src, srcIndex, label)
 	} else if dest.IsTable {
 		// Case 2: Variable to Array Element (x[n] := b)
 		if srcIndex != "" {
-			return fmt.Errorf("Bledne uzycie zmiennej %s", src.Name)
+			return misuse("invalid use of array %s", src.Name)
 		}
 		return t.handleVarToArrayAssign(*dest, *src, destIndex, label)
 	} else {
 		if destIndex != "" {
-			return fmt.Errorf("Bledne uzycie zmiennej %s", dest.Name)
+			return misuse("invalid use of array %s", dest.Name)
 		}
 		if srcIndex != "" {
-			return fmt.Errorf("Bledne uzycie zmiennej %s", src.Name)
+			return misuse("invalid use of array %s", src.Name)
 		}
 		// Case 1: Variable to Variable (a := b)
 		return t.handleVarToVarAssign(*dest, *src, destIndex, srcIndex, label)
@@ -443,10 +486,10 @@ func (t *Translator) handleAssign(ins tac.Instruction) error {
 
 func (t *Translator) handleVarToVarAssign(dest, src symboltable.Symbol, destI, srcI string, label []string) error {
 	if destI != "" {
-		return fmt.Errorf("Nieprawdilowe uzycie zmiennej %v. Nie jest tablica", dest)
+		return misuse("%s is not an array", dest.Name)
 	}
 	if srcI != "" {
-		return fmt.Errorf("Nieprawdilowe uzycie zmiennej %v. Nie jest tablica", src)
+		return misuse("%s is not an array", src.Name)
 
 	}
 	destArgument := dest.Kind == symboltable.ARGUMENT
@@ -493,9 +536,6 @@ func (t *Translator) handleVarToArrayAssign(dest, src symboltable.Symbol, destIn
 	destArgument := dest.Kind == symboltable.ARGUMENT
 	srcArgument := src.Kind == symboltable.ARGUMENT
 
-	if !dest.IsTable {
-		fmt.Errorf("Nieprawidlowe uzycie zmiennej %v", dest.Name)
-	}
 	if destArgument {
 		t.emit(code.Instruction{
 			Op:         code.LOAD,
@@ -517,10 +557,7 @@ func (t *Translator) handleVarToArrayAssign(dest, src symboltable.Symbol, destIn
 	// Add the base address
 	indexSymbol, err := t.getSymbol(destIndex)
 	if err != nil {
-		return fmt.Errorf("NIEPRAWIDLOWE UZYCIE TABLICY: %v", err)
-	}
-	if indexSymbol.Kind == symboltable.DECLARATION && indexSymbol.IsTable == false && t.initializedEntries[indexSymbol.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", indexSymbol.Name)
+		return misuse("invalid array index: %v", err)
 	}
 	if indexSymbol.Kind == symboltable.ARGUMENT {
 		t.emit(code.Instruction{
@@ -580,9 +617,6 @@ func (t *Translator) handleVarToArrayAssign(dest, src symboltable.Symbol, destIn
 }
 
 func (t *Translator) handleArrayToVarAssign(dest, src symboltable.Symbol, srcIndex string, label []string) error {
-	if !dest.IsTable {
-		fmt.Errorf("Nieprawidlowe uzycie zmiennej %v", dest.Name)
-	}
 	destArgument := dest.Kind == symboltable.ARGUMENT
 	if err := t.loadOperandIndirect(src, srcIndex, label); err != nil {
 		return err
@@ -608,17 +642,17 @@ func (t *Translator) handleArrayToVarAssign(dest, src symboltable.Symbol, srcInd
 
 func (t *Translator) handleArrayToArrayAssign(dest, src symboltable.Symbol, srcIndex, destIndex string, labels []string) error {
 	if destIndex == "" {
-		return fmt.Errorf("Brak indeksu dla zmiennej %s", dest.Name)
+		return misuse("array %s used without an index", dest.Name)
 	}
 	if srcIndex == "" {
-		return fmt.Errorf("Brak indeksu dla zmiennej %s", src.Name)
+		return misuse("array %s used without an index", src.Name)
 	}
 	destArgument := dest.Kind == symboltable.ARGUMENT
 	if !src.IsTable {
-		return fmt.Errorf("Nieprawidlowe uzycie zmiennej %v", src.Name)
+		return misuse("invalid use of variable %s", src.Name)
 	}
 	if !dest.IsTable {
-		return fmt.Errorf("Nieprawidlowe uzycie zmiennej %v", dest.Name)
+		return misuse("invalid use of variable %s", dest.Name)
 	}
 	if destArgument {
 		t.emit(code.Instruction{
@@ -636,11 +670,8 @@ func (t *Translator) handleArrayToArrayAssign(dest, src symboltable.Symbol, srcI
 		})
 	}
 	indexSymbol, err := t.getSymbol(destIndex)
-	if indexSymbol.Kind == symboltable.DECLARATION && !indexSymbol.IsTable && t.initializedEntries[indexSymbol.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", indexSymbol.Name)
-	}
 	if err != nil {
-		return fmt.Errorf("NIEPRAWIDLOWE UZYCIE TABLICY: %v", err)
+		return misuse("invalid array index: %v", err)
 
 	}
 	if indexSymbol.Kind == symboltable.ARGUMENT {
@@ -688,13 +719,6 @@ func (t *Translator) handleArrayToArrayAssign(dest, src symboltable.Symbol, srcI
 
 func (t *Translator) handleAddSub(ins tac.Instruction) error {
 
-	t.Initialize(ins.Destination)
-	if ins.Arg2.Kind == symboltable.DECLARATION && !ins.Arg2.IsTable && t.initializedEntries[ins.Arg2.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", ins.Arg2.Name)
-	}
-	if ins.Arg1.Kind == symboltable.DECLARATION && !ins.Arg1.IsTable && t.initializedEntries[ins.Arg1.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", ins.Arg1.Name)
-	}
 	dest := ins.Destination
 	Arg1Index := ins.Arg1Index
 	arg1 := ins.Arg1
@@ -1266,15 +1290,12 @@ func (t *Translator) VarMinusVarLoad(arg1 symboltable.Symbol, arg2 symboltable.S
 // loadOperandIndirect computes the address of the array element and uses LOADI to load its value into ACC.
 func (t *Translator) loadOperandIndirect(operand symboltable.Symbol, operandIndex string, labels []string) error {
 	if operandIndex == "" {
-		return fmt.Errorf("Bledne uzycie tablicy. Nie ma indeksu")
+		return misuse("array used without an index")
 	}
 	// Compute the address: baseAddr + (index - fromVal)
 	indexSymbol, err := t.St.Lookup(operandIndex, t.currentFunctionName)
-	if indexSymbol.Kind == symboltable.DECLARATION && !indexSymbol.IsTable && t.initializedEntries[indexSymbol.Name] == false {
-		return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", indexSymbol.Name)
-	}
 	if err != nil {
-		return fmt.Errorf("NIEWLASCIWE UZYCIE TABLICY: failed to lookup the symbol for the index %d: %v", operandIndex, err)
+		return misuse("invalid array index %q: %v", operandIndex, err)
 	}
 	if operand.Kind == symboltable.ARGUMENT {
 		t.emit(code.Instruction{
@@ -1376,10 +1397,6 @@ func (t *Translator) handleDiv(ins tac.Instruction) error {
 	if ins.Arg2.Name == "2" {
 		destArgument := ins.Destination.Kind == symboltable.ARGUMENT
 		arg1Argument := ins.Arg1.Kind == symboltable.ARGUMENT
-		if ins.Arg1.Kind == symboltable.DECLARATION && t.initializedEntries[ins.Arg1.Name] == false {
-			return fmt.Errorf("Uzycie nie zainicjalizowanej zmiennej %s", ins.Arg1.Name)
-		}
-		t.Initialize(ins.Destination)
 		if arg1Argument {
 			t.emit(code.Instruction{
 				Op:         code.LOADI,
@@ -1418,8 +1435,27 @@ func (t *Translator) handleDiv(ins tac.Instruction) error {
 }
 
 func (t *Translator) handleParam(param *symboltable.Symbol, labels []string) error {
-	t.Initialize(param)
-	if param.Kind == symboltable.ARGUMENT {
+	if t.frame != nil && param.Kind != symboltable.ARGUMENT && t.inFrame(param) {
+		// A recursive call gets the address of the caller's copy on the
+		// stack, so writes through it survive popping the frame.
+		cells, slots := t.frameLayout(t.frame.Name)
+		offset := slots[param.Address] - len(cells)
+		if param.IsTable {
+			offset = slots[param.Address+param.From] - len(cells) - param.From
+		}
+		t.emit(code.Instruction{
+			Op:         code.SET,
+			HasOperand: true,
+			Labels:     labels,
+			Operand:    offset,
+		})
+		t.emit(code.Instruction{
+			Op:         code.ADD,
+			HasOperand: true,
+			Operand:    t.stackPointer(),
+			Comment:    "address in the caller's frame",
+		})
+	} else if param.Kind == symboltable.ARGUMENT {
 		t.emit(code.Instruction{
 			Op:         code.LOAD,
 			HasOperand: true,
@@ -1451,6 +1487,7 @@ func (t *Translator) handleParam(param *symboltable.Symbol, labels []string) err
 }
 
 func (t *Translator) handleCall(ins tac.Instruction) error {
+	t.frame = nil
 	procSym, err := t.St.Lookup(ins.Arg1.Name, "xxFunctionsxx")
 	if err != nil {
 		return fmt.Errorf("failed finding a functon called %s", ins.Arg1.Name)
@@ -1480,7 +1517,7 @@ func (t *Translator) handleCall(ins tac.Instruction) error {
 		})
 
 		if procSym.Arguments[argCount-i].IsTable != t.paramTable[t.paramCount-i] {
-			return fmt.Errorf("Niewlasciwy parametr procedury %s", procSym.Name)
+			return diag.Errorf(diag.ErrArgumentKind, diag.Span{}, "argument kind does not match parameter of procedure %s", procSym.Name)
 		}
 	}
 	returnSymbol, err := t.St.Lookup(ins.Arg1.Name+"_return", "xxFunctionsxx")
@@ -1516,6 +1553,117 @@ func (t *Translator) handleCall(ins tac.Instruction) error {
 	return nil
 }
 
+// handleJumpTable emits a block of jumps, one per table entry, and an RTRN
+// to the entry selected by Arg1 - Arg2.
+func (t *Translator) handleJumpTable(ins tac.Instruction, labels []string) error {
+	first, err := strconv.Atoi(ins.Arg2.Name)
+	if err != nil {
+		return fmt.Errorf("jump table starts at non-constant %s", ins.Arg2.Name)
+	}
+	// SET, ADD, STORE and RTRN come before the block.
+	block := len(t.Output) + 4
+	t.emit(code.Instruction{Op: code.SET, HasOperand: true, Operand: block - first, Labels: labels, Comment: "jump table"})
+	add := code.ADD
+	if ins.Arg1.Kind == symboltable.ARGUMENT {
+		add = code.ADDI
+	}
+	t.emit(code.Instruction{Op: add, HasOperand: true, Operand: ins.Arg1.Address})
+	t.emit(code.Instruction{Op: code.STORE, HasOperand: true, Operand: t.pointerCell})
+	t.emit(code.Instruction{Op: code.RTRN, HasOperand: true, Operand: t.pointerCell})
+	for _, target := range ins.Targets {
+		t.emit(code.Instruction{Op: code.JUMP, HasOperand: true, Comment: "to " + target, Destination: target})
+	}
+	return nil
+}
+
+// stackPointer is the cell holding the address of the first free stack
+// cell. The stack starts right after it and grows upwards.
+func (t *Translator) stackPointer() int {
+	return t.pointerCell + 1
+}
+
+// setupStack initialises the stack pointer. Programs without recursive
+// procedures never touch the stack and skip it.
+func (t *Translator) setupStack() {
+	for _, sym := range t.St.Table["xxFunctionsxx"] {
+		if sym.Kind == symboltable.PROCEDURE && sym.Recursive {
+			t.emit(code.Instruction{Op: code.SET, HasOperand: true, Operand: t.stackPointer() + 1, Comment: "stack base"})
+			t.emit(code.Instruction{Op: code.STORE, HasOperand: true, Operand: t.stackPointer(), Comment: "stack pointer"})
+			return
+		}
+	}
+}
+
+// frameLayout returns the cells making up an activation record of proc:
+// its return address, arguments, locals, iterators and temporaries, in
+// address order. slots maps every cell to its offset in the frame.
+func (t *Translator) frameLayout(proc string) ([]int, map[int]int) {
+	var cells []int
+	for _, sym := range t.St.Table[proc] {
+		if sym.IsTable && sym.Kind != symboltable.ARGUMENT {
+			for i := sym.From; i <= sym.To; i++ {
+				cells = append(cells, sym.Address+i)
+			}
+		} else {
+			cells = append(cells, sym.Address)
+		}
+	}
+	if ret, err := t.St.Lookup(proc+"_return", "xxFunctionsxx"); err == nil {
+		cells = append(cells, ret.Address)
+	}
+	sort.Ints(cells)
+	slots := make(map[int]int, len(cells))
+	for i, cell := range cells {
+		slots[cell] = i
+	}
+	return cells, slots
+}
+
+// inFrame reports whether sym lives in the frame pushed for the current call.
+func (t *Translator) inFrame(sym *symboltable.Symbol) bool {
+	local, ok := t.St.Table[t.frame.Name][sym.Name]
+	return ok && local.Address == sym.Address
+}
+
+// handlePushFrame copies the activation record of proc onto the stack
+// before proc calls itself.
+func (t *Translator) handlePushFrame(proc *symboltable.Symbol, labels []string) error {
+	one, err := t.St.Lookup("1", symboltable.Global)
+	if err != nil {
+		return err
+	}
+	cells, _ := t.frameLayout(proc.Name)
+	for _, cell := range cells {
+		t.emit(code.Instruction{Op: code.LOAD, HasOperand: true, Operand: cell, Labels: labels, Comment: "push frame of " + proc.Name})
+		labels = nil
+		t.emit(code.Instruction{Op: code.STOREI, HasOperand: true, Operand: t.stackPointer()})
+		t.emit(code.Instruction{Op: code.LOAD, HasOperand: true, Operand: t.stackPointer()})
+		t.emit(code.Instruction{Op: code.ADD, HasOperand: true, Operand: one.Address})
+		t.emit(code.Instruction{Op: code.STORE, HasOperand: true, Operand: t.stackPointer()})
+	}
+	t.frame = proc
+	return nil
+}
+
+// handlePopFrame restores the activation record of proc once its recursive
+// call has returned.
+func (t *Translator) handlePopFrame(proc *symboltable.Symbol, labels []string) error {
+	one, err := t.St.Lookup("1", symboltable.Global)
+	if err != nil {
+		return err
+	}
+	cells, _ := t.frameLayout(proc.Name)
+	for i := len(cells) - 1; i >= 0; i-- {
+		t.emit(code.Instruction{Op: code.LOAD, HasOperand: true, Operand: t.stackPointer(), Labels: labels, Comment: "pop frame of " + proc.Name})
+		labels = nil
+		t.emit(code.Instruction{Op: code.SUB, HasOperand: true, Operand: one.Address})
+		t.emit(code.Instruction{Op: code.STORE, HasOperand: true, Operand: t.stackPointer()})
+		t.emit(code.Instruction{Op: code.LOADI, HasOperand: true, Operand: t.stackPointer()})
+		t.emit(code.Instruction{Op: code.STORE, HasOperand: true, Operand: cells[i]})
+	}
+	return nil
+}
+
 func (t *Translator) handleRet(labels []string) error {
 	returnAddr, err := t.St.Lookup(t.currentFunctionName+"_return", "xxFunctionsxx")
 	if err != nil {
@@ -1532,6 +1680,24 @@ func (t *Translator) handleHalt(labels []string) {
 	t.emit(code.Instruction{Labels: labels, Comment: "halt", Op: code.HALT})
 }
 
+// handleAsm copies a machine instruction of an ASM block. A variable
+// operand becomes the address of its cell; for a reference parameter that
+// cell holds the address of the argument. Jumps to labels of the block are
+// resolved by secondPass like any other.
+func (t *Translator) handleAsm(ins tac.Instruction, labels []string) {
+	out := code.Instruction{Op: ins.Opcode, Labels: labels, Comment: "asm"}
+	switch {
+	case ins.JumpTo != "":
+		out.HasOperand, out.Destination = true, ins.JumpTo
+	case ins.Arg1 != nil:
+		out.HasOperand, out.Operand = true, ins.Arg1.Address
+	default:
+		operands, _ := code.Operands(ins.Opcode)
+		out.HasOperand, out.Operand = operands == 1, ins.Operand
+	}
+	t.emit(out)
+}
+
 func (t *Translator) handleGoto(labelName string, labels []string) {
 	t.emit(code.Instruction{Op: code.JUMP, Comment: "goto " + labelName, Labels: labels, HasOperand: true, Destination: labelName})
 }
@@ -1584,7 +1750,7 @@ func (t *Translator) emit(code code.Instruction) {
 func (t *Translator) getSymbol(name string) (*symboltable.Symbol, error) {
 	_, err := strconv.Atoi(name)
 	if err == nil {
-		symbol, err := t.St.Lookup(name, "main")
+		symbol, err := t.St.Lookup(name, symboltable.Global)
 		if err != nil {
 			return nil, fmt.Errorf("failed to find addr for %q: %v", name, err)
 		}